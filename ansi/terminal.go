@@ -0,0 +1,27 @@
+package ansi
+
+import (
+	"io"
+	"os"
+)
+
+// IsTerminal reports whether writer is a terminal that supports escape codes.
+// Writers other than files, like pipes and buffers, are not terminals, and
+// neither is any terminal when TERM is "dumb".
+func IsTerminal(writer io.Writer) bool {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
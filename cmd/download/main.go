@@ -1,30 +1,450 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/mdelillo/go-utils/files"
 	"github.com/mdelillo/go-utils/net"
+	"io"
+	"io/fs"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// exitInterrupted is the exit code when the downloads are interrupted, which
+// shells use for processes killed by SIGINT.
+const exitInterrupted = 130
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Printf("Usage: %s <url> [<url> ...]\n\n", os.Args[0])
-		fmt.Printf("Example: %s http://ipv4.download.thinkbroadband.com/5MB.zip http://ipv4.download.thinkbroadband.com/20MB.zip http://ipv4.download.thinkbroadband.com/50MB.zip\n", os.Args[0])
-		os.Exit(1)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	os.Exit(run(os.Args[0], os.Args[1:], os.Stdout, os.Stderr, signals))
+}
+
+// run downloads the files given by args and returns the exit code. The
+// downloads are cancelled when a signal is received.
+func run(name string, args []string, stdout, stderr io.Writer, signals <-chan os.Signal) int {
+	cfg, err := parseFlags(name, args, stdout)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
 	}
 
-	var fileDownloads []net.FileDownload
-	for _, url := range os.Args[1:] {
-		filePath := filepath.Base(url)
-		fileDownloads = append(fileDownloads, net.FileDownload{
-			URL:      url,
-			FilePath: filePath,
+	fileDownloads, err := cfg.fileDownloads()
+	if err != nil {
+		fmt.Fprintf(stdout, "Error: %s\n", err.Error())
+		return 1
+	}
+
+	jar, err := cfg.cookieJar()
+	if err != nil {
+		fmt.Fprintf(stdout, "Error: %s\n", err.Error())
+		return 1
+	}
+
+	ctx, stop := cancelOnInterrupt(signals, stdout)
+	defer stop()
+
+	results := net.DownloadFilesWithProgressResults(fileDownloads,
+		net.WithDownloadContext(ctx),
+		net.WithFileDownloader(cfg.fileDownloader(jar)),
+		net.WithProgressMode(net.ProgressMode(cfg.progressMode)),
+		net.WithProgressWriter(stdout),
+	)
+
+	// The summary would get in the way of tools reading the JSON events.
+	summaryOutput := stdout
+	if net.ProgressMode(cfg.progressMode) == net.ProgressModeJSON {
+		summaryOutput = stderr
+	}
+
+	if err := cfg.saveCookies(jar); err != nil {
+		fmt.Fprintf(stdout, "Error: %s\n", err.Error())
+		return 1
+	}
+
+	fmt.Fprintln(summaryOutput)
+	if err := results.WriteSummary(summaryOutput); err != nil {
+		fmt.Fprintf(stdout, "Error: %s\n", err.Error())
+		return 1
+	}
+
+	if cancelled := results.Cancelled(); len(cancelled) > 0 {
+		fmt.Fprintf(summaryOutput, "\nInterrupted, %d of %d downloads were cancelled\n", len(cancelled), len(results))
+		if cfg.keepPartial {
+			fmt.Fprintln(summaryOutput, "Run the same command again to resume them")
+		}
+		return exitInterrupted
+	}
+
+	if failed := results.Failed(); len(failed) > 0 {
+		fmt.Fprintf(summaryOutput, "\n%d of %d downloads failed\n", len(failed), len(results))
+		return 1
+	}
+
+	return 0
+}
+
+// cancelOnInterrupt returns a context which is cancelled on the first signal,
+// so that the downloads stop cleanly. A second signal exits immediately. stop
+// stops waiting for signals.
+func cancelOnInterrupt(signals <-chan os.Signal, output io.Writer) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-signals:
+			// Move past the progress, which the cursor may be in the middle
+			// of, before giving the terminal back.
+			fmt.Fprintln(output)
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			close(done)
+			cancel()
 		})
 	}
+}
+
+type config struct {
+	urls         []string
+	manifestPath string
+	dir          string
+	output       string
+	concurrency  int
+	headers      map[string]string
+	userAgent    string
+	basicAuth    string
+	timeout      time.Duration
+	rateLimits   domainDurations
+	retries      domainInts
+	retryBackoff time.Duration
+	progressMode string
+	keepPartial  bool
+	cookiesPath  string
+}
+
+func parseFlags(name string, args []string, output io.Writer) (config, error) {
+	cfg := config{
+		headers:    map[string]string{},
+		rateLimits: domainDurations{},
+		retries:    domainInts{},
+	}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(output)
+
+	stringFlag(flags, &cfg.manifestPath, "input", "i", "", "read downloads from a manifest: a .json or .csv file, or a file with a URL on each line")
+	stringFlag(flags, &cfg.dir, "dir", "d", ".", "save files to this directory")
+	stringFlag(flags, &cfg.output, "output", "o", "", "save the file with this name, for a single URL")
+	flags.IntVar(&cfg.concurrency, "concurrency", 1, "number of files to download at once")
+	flags.IntVar(&cfg.concurrency, "c", 1, "shorthand for -concurrency")
+	flags.Var(headerFlag(cfg.headers), "header", `add a header to every request, like "Name: value" (repeatable)`)
+	flags.Var(headerFlag(cfg.headers), "H", "shorthand for -header")
+	stringFlag(flags, &cfg.userAgent, "user-agent", "A", "", "send this User-Agent header")
+	stringFlag(flags, &cfg.basicAuth, "user", "u", "", `authenticate with "user:password"`)
+	stringFlag(flags, &cfg.cookiesPath, "cookies", "b", "", "send the cookies in this Netscape cookies.txt file, and save the cookies set by servers back to it")
+	flags.DurationVar(&cfg.timeout, "timeout", time.Hour, "time limit for each request, including reading the file")
+	flags.Var(cfg.rateLimits, "rate-limit", `minimum delay between requests, like "1s", or "example.com=1s" for a domain and its subdomains (repeatable)`)
+	flags.Var(cfg.retries, "retries", `attempts for requests that get a 5XX response, like "3", or "example.com=3" for a domain and its subdomains (repeatable)`)
+	flags.DurationVar(&cfg.retryBackoff, "retry-backoff", time.Second, "delay before the first retry, which doubles with each retry")
+	flags.BoolVar(&cfg.keepPartial, "keep-partial", false, "when interrupted, keep what was downloaded so that running the command again resumes it, instead of removing incomplete files")
+	flags.StringVar(&cfg.progressMode, "progress", string(net.ProgressModeAuto), "how to show progress: auto, interactive, plain or json (newline-delimited events)")
+
+	flags.Usage = func() {
+		fmt.Fprintf(output, "Usage: %s [flags] [<url> ...]\n\n", name)
+		fmt.Fprintf(output, "Downloads files, showing their progress.\n\n")
+		fmt.Fprintf(output, "Example: %s -d downloads -c 2 -H 'Authorization: Bearer some-token' http://ipv4.download.thinkbroadband.com/5MB.zip http://ipv4.download.thinkbroadband.com/20MB.zip\n\n", name)
+		fmt.Fprintf(output, "Flags:\n")
+		flags.PrintDefaults()
+	}
+
+	// Parse errors are printed along with the usage by the flag set.
+	if err := flags.Parse(args); err != nil {
+		return config{}, err
+	}
+	cfg.urls = flags.Args()
+
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintf(output, "Error: %s\n\n", err.Error())
+		flags.Usage()
+		return config{}, err
+	}
+
+	return cfg, nil
+}
+
+func (c config) validate() error {
+	if len(c.urls) == 0 && c.manifestPath == "" {
+		return errors.New("no URLs or manifest given")
+	}
+	if c.output != "" {
+		if c.manifestPath != "" || len(c.urls) != 1 {
+			return errors.New("-output can only be used with a single URL")
+		}
+		if strings.ContainsAny(c.output, `/\`) {
+			return fmt.Errorf("-output must be a file name, use -dir for the directory: %q", c.output)
+		}
+	}
+	if c.concurrency < 1 {
+		return fmt.Errorf("-concurrency must be at least 1, got %d", c.concurrency)
+	}
+	if c.basicAuth != "" && !strings.Contains(c.basicAuth, ":") {
+		return errors.New(`-user must be "user:password"`)
+	}
+	if c.timeout <= 0 {
+		return fmt.Errorf("-timeout must be positive, got %s", c.timeout)
+	}
+	if c.retryBackoff < 0 {
+		return fmt.Errorf("-retry-backoff cannot be negative, got %s", c.retryBackoff)
+	}
+	switch net.ProgressMode(c.progressMode) {
+	case net.ProgressModeAuto, net.ProgressModeInteractive, net.ProgressModePlain, net.ProgressModeJSON:
+	default:
+		return fmt.Errorf("-progress must be auto, interactive, plain or json, got %q", c.progressMode)
+	}
+
+	return nil
+}
+
+func (c config) fileDownloads() ([]net.FileDownload, error) {
+	var fileDownloads []net.FileDownload
+	if c.manifestPath != "" {
+		manifestDownloads, err := net.ReadManifest(c.manifestPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fileDownload := range manifestDownloads {
+			if fileDownload.FilePath != "" && !filepath.IsAbs(fileDownload.FilePath) {
+				fileDownload.FilePath = filepath.Join(c.dir, fileDownload.FilePath)
+			} else if fileDownload.Dir != "" && !filepath.IsAbs(fileDownload.Dir) {
+				fileDownload.Dir = filepath.Join(c.dir, fileDownload.Dir)
+			}
+			fileDownloads = append(fileDownloads, fileDownload)
+		}
+	}
+
+	for _, url := range c.urls {
+		fileDownload := net.FileDownload{URL: url, Dir: c.dir}
+		if c.output != "" {
+			fileDownload = net.FileDownload{URL: url, FilePath: filepath.Join(c.dir, c.output)}
+		}
+		fileDownloads = append(fileDownloads, fileDownload)
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return fileDownloads, nil
+}
+
+// cookieJar returns a jar with the cookies from -cookies, or nil if it was not
+// given. The file does not need to exist yet.
+func (c config) cookieJar() (*net.PersistableCookieJar, error) {
+	if c.cookiesPath == "" {
+		return nil, nil
+	}
+
+	jar := net.NewPersistableCookieJar(nil)
+
+	file, err := os.Open(c.cookiesPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return jar, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cookies: %w", err)
+	}
+	defer file.Close()
+
+	if err := jar.ImportCookiesTxt(file); err != nil {
+		return nil, fmt.Errorf("failed to read cookies from %s: %w", c.cookiesPath, err)
+	}
+
+	return jar, nil
+}
+
+func (c config) saveCookies(jar *net.PersistableCookieJar) error {
+	if jar == nil {
+		return nil
+	}
+
+	var cookies strings.Builder
+	if err := jar.ExportCookiesTxt(&cookies); err != nil {
+		return err
+	}
+
+	// The cookies may include session tokens, so only the user can read them.
+	if err := files.WriteFile(c.cookiesPath, []byte(cookies.String()), 0600); err != nil {
+		return fmt.Errorf("failed to save cookies: %w", err)
+	}
+
+	return nil
+}
+
+func (c config) fileDownloader(jar *net.PersistableCookieJar) *net.FileDownloader {
+	clientOptions := []net.ClientOption{net.WithTimeout(c.timeout)}
+	if jar != nil {
+		clientOptions = append(clientOptions, net.WithCookieJar(jar))
+	}
+
+	browserOptions := []net.BrowserOption{
+		net.WithClient(net.NewHTTPClient(clientOptions...)),
+		net.WithDefaultHeaders(c.headers),
+	}
+
+	if c.userAgent != "" {
+		browserOptions = append(browserOptions, net.WithDefaultUserAgent(c.userAgent))
+	}
+
+	if c.basicAuth != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(c.basicAuth))
+		browserOptions = append(browserOptions, net.WithDefaultHeader("Authorization", "Basic "+credentials))
+	}
+
+	if len(c.rateLimits) > 0 {
+		rateLimiter := &net.PerDomainRateLimiter{DomainRateLimiters: map[string]net.RateLimiter{}}
+		for domain, delay := range c.rateLimits {
+			if domain == "" {
+				rateLimiter.DefaultRateLimiter = &net.BasicRateLimiter{RequestDelay: delay}
+			} else {
+				rateLimiter.DomainRateLimiters[domain] = &net.BasicRateLimiter{RequestDelay: delay}
+			}
+		}
+		browserOptions = append(browserOptions, net.WithDefaultRateLimiter(rateLimiter))
+	}
+
+	if len(c.retries) > 0 {
+		retrier := &net.PerDomainRetrier{DomainRetriers: map[string]net.Retrier{}}
+		for domain, attempts := range c.retries {
+			domainRetrier := net.ExponentialBackoffRetrier{InitialBackoff: c.retryBackoff, MaxAttempts: attempts}
+			if domain == "" {
+				retrier.DefaultRetrier = domainRetrier
+			} else {
+				retrier.DomainRetriers[domain] = domainRetrier
+			}
+		}
+		browserOptions = append(browserOptions, net.WithDefaultRetrier(retrier))
+	}
+
+	// Running the same command again replaces the files it downloaded, rather
+	// than saving new copies next to them.
+	return &net.FileDownloader{
+		Browser:         net.NewBrowser(browserOptions...),
+		Concurrency:     c.concurrency,
+		KeepPartial:     c.keepPartial,
+		ReplaceExisting: true,
+	}
+}
+
+// stringFlag defines a string flag with a long and a short name.
+func stringFlag(flags *flag.FlagSet, p *string, name, shorthand, value, usage string) {
+	flags.StringVar(p, name, value, usage)
+	flags.StringVar(p, shorthand, value, "shorthand for -"+name)
+}
+
+// headerFlag collects headers given as "Name: value".
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	var headers []string
+	for name, value := range h {
+		headers = append(headers, name+": "+value)
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (h headerFlag) Set(header string) error {
+	name, value, ok := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf(`expected "Name: value", got %q`, header)
+	}
+	h[name] = strings.TrimSpace(value)
+	return nil
+}
+
+// domainDurations collects durations given as "<duration>" for every domain,
+// or "<domain>=<duration>" for a domain.
+type domainDurations map[string]time.Duration
+
+func (d domainDurations) String() string {
+	var values []string
+	for domain, duration := range d {
+		values = append(values, formatDomainValue(domain, duration.String()))
+	}
+	return strings.Join(values, ", ")
+}
+
+func (d domainDurations) Set(value string) error {
+	domain, rawDuration := splitDomainValue(value)
+	duration, err := time.ParseDuration(rawDuration)
+	if err != nil || duration < 0 {
+		return fmt.Errorf(`expected "<duration>" or "<domain>=<duration>", got %q`, value)
+	}
+	d[domain] = duration
+	return nil
+}
+
+// domainInts collects numbers given as "<number>" for every domain, or
+// "<domain>=<number>" for a domain.
+type domainInts map[string]int
+
+func (d domainInts) String() string {
+	var values []string
+	for domain, n := range d {
+		values = append(values, formatDomainValue(domain, strconv.Itoa(n)))
+	}
+	return strings.Join(values, ", ")
+}
+
+func (d domainInts) Set(value string) error {
+	domain, rawNumber := splitDomainValue(value)
+	n, err := strconv.Atoi(rawNumber)
+	if err != nil || n < 1 {
+		return fmt.Errorf(`expected a positive "<number>" or "<domain>=<number>", got %q`, value)
+	}
+	d[domain] = n
+	return nil
+}
+
+func splitDomainValue(value string) (string, string) {
+	domain, rest, ok := strings.Cut(value, "=")
+	if !ok {
+		return "", value
+	}
+	return strings.ToLower(strings.TrimSpace(domain)), strings.TrimSpace(rest)
+}
 
-	if err := net.DownloadFilesWithProgress(fileDownloads); err != nil {
-		fmt.Printf("Error: %s\n", err.Error())
-		os.Exit(1)
+func formatDomainValue(domain, value string) string {
+	if domain == "" {
+		return value
 	}
+	return domain + "=" + value
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDownload(t *testing.T) {
+	spec.Run(t, "Download", testDownload, spec.Report(report.Terminal{}))
+}
+
+func testDownload(t *testing.T, context spec.G, it spec.S) {
+	var (
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	context("parseFlags", func() {
+		it("parses valid flags", func() {
+			var output bytes.Buffer
+			cfg, err := parseFlags("download", []string{"-c", "2", "-H", "Authorization: Bearer some-token", "-u", "some-user:some-password", "-progress", "json", "http://example.com/some-file"}, &output)
+			require.NoError(err)
+
+			assert.Equal([]string{"http://example.com/some-file"}, cfg.urls)
+			assert.Equal(2, cfg.concurrency)
+			assert.Equal(map[string]string{"Authorization": "Bearer some-token"}, cfg.headers)
+			assert.Equal("some-user:some-password", cfg.basicAuth)
+			assert.Equal("json", cfg.progressMode)
+			assert.Empty(output.String())
+		})
+
+		it("returns an error and prints the usage for invalid flags", func() {
+			for _, test := range []struct {
+				args          []string
+				expectedError string
+			}{
+				{
+					args:          nil,
+					expectedError: "no URLs or manifest given",
+				},
+				{
+					args:          []string{"-o", "some-file", "http://example.com/a", "http://example.com/b"},
+					expectedError: "-output can only be used with a single URL",
+				},
+				{
+					args:          []string{"-concurrency", "0", "http://example.com/a"},
+					expectedError: "-concurrency must be at least 1, got 0",
+				},
+				{
+					args:          []string{"-H", "no-colon", "http://example.com/a"},
+					expectedError: `invalid value "no-colon" for flag -H: expected "Name: value", got "no-colon"`,
+				},
+				{
+					args:          []string{"-progress", "fancy", "http://example.com/a"},
+					expectedError: `-progress must be auto, interactive, plain or json, got "fancy"`,
+				},
+				{
+					args:          []string{"-user", "no-colon", "http://example.com/a"},
+					expectedError: `-user must be "user:password"`,
+				},
+			} {
+				var output bytes.Buffer
+				_, err := parseFlags("download", test.args, &output)
+				assert.EqualError(err, test.expectedError, "args: %q", test.args)
+				assert.Contains(output.String(), "Usage: download [flags] [<url> ...]", "args: %q", test.args)
+			}
+		})
+	})
+
+	context("downloading", func() {
+		it("replaces the files it downloaded when it is run again", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("some-contents"))
+			}))
+			defer server.Close()
+			dir := t.TempDir()
+
+			cfg, err := parseFlags("download", []string{"-d", dir, "-progress", "plain", server.URL + "/file.zip"}, &bytes.Buffer{})
+			require.NoError(err)
+
+			for i := 0; i < 2; i++ {
+				fileDownloads, err := cfg.fileDownloads()
+				require.NoError(err)
+
+				results := cfg.fileDownloader(nil).DownloadFilesWithResults(fileDownloads, func(_ net.DownloadProgress) {})
+				require.NoError(results.Err())
+				assert.Equal(filepath.Join(dir, "file.zip"), results[0].FileDownload.FilePath)
+			}
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(err)
+			assert.Len(entries, 1)
+		})
+
+		it("cancels the downloads and removes partial files when interrupted", func() {
+			started := make(chan struct{})
+			var once sync.Once
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "10000")
+				if r.Method == http.MethodHead {
+					return
+				}
+
+				_, _ = w.Write(bytes.Repeat([]byte("0"), 1000))
+				w.(http.Flusher).Flush()
+				once.Do(func() { close(started) })
+				<-r.Context().Done()
+			}))
+			defer server.Close()
+			dir := t.TempDir()
+
+			signals := make(chan os.Signal, 1)
+			exitCode := make(chan int)
+			var stdout, stderr bytes.Buffer
+			go func() {
+				exitCode <- run("download", []string{"-d", dir, "-progress", "plain", server.URL + "/file.zip"}, &stdout, &stderr, signals)
+			}()
+
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the download to start")
+			}
+			signals <- syscall.SIGINT
+
+			select {
+			case code := <-exitCode:
+				assert.Equal(exitInterrupted, code)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the downloads to be cancelled")
+			}
+
+			assert.Contains(stdout.String(), "Interrupted, 1 of 1 downloads were cancelled")
+			entries, err := os.ReadDir(dir)
+			require.NoError(err)
+			assert.Empty(entries)
+		})
+	})
+}
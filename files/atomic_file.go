@@ -0,0 +1,198 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const tempFileSuffix = ".tmp"
+
+// AtomicFile is a temporary file in the same directory as its target path. It
+// is only moved into place by Commit, so readers of the target path never see
+// a partially written file.
+type AtomicFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+// CreateAtomic creates a temporary file for path. Its permissions are perm
+// less the umask, as they would be if it were created with os.OpenFile.
+func CreateAtomic(path string, perm os.FileMode) (*AtomicFile, error) {
+	file, err := os.CreateTemp(filepath.Dir(path), tempFilePattern(path))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := file.Chmod(perm &^ umask()); err != nil && runtime.GOOS != "windows" {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+
+	return &AtomicFile{File: file, path: path}, nil
+}
+
+// Path returns the path the file will be moved to when it is committed.
+func (f *AtomicFile) Path() string {
+	return f.path
+}
+
+// Commit flushes the file to disk and renames it to its target path.
+func (f *AtomicFile) Commit() error {
+	return f.CommitAs(f.path)
+}
+
+// CommitAs flushes the file to disk and renames it to path instead of its
+// target path.
+func (f *AtomicFile) CommitAs(path string) error {
+	if f.done {
+		return errors.New("file has already been committed or aborted")
+	}
+	f.done = true
+
+	if err := f.File.Sync(); err != nil {
+		_ = f.File.Close()
+		_ = os.Remove(f.File.Name())
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	if err := f.File.Close(); err != nil {
+		_ = os.Remove(f.File.Name())
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	if err := os.Rename(f.File.Name(), path); err != nil {
+		_ = os.Remove(f.File.Name())
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+
+	return nil
+}
+
+// Abort closes and removes the temporary file. It does nothing if the file has
+// already been committed, so it is safe to defer.
+func (f *AtomicFile) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	closeErr := f.File.Close()
+
+	if err := os.Remove(f.File.Name()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if closeErr != nil && !errors.Is(closeErr, os.ErrClosed) {
+		return closeErr
+	}
+
+	return nil
+}
+
+// WriteFile is like os.WriteFile, but readers of path will either see the
+// previous contents or all of data.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	file, err := CreateAtomic(path, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Abort()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+
+	return file.Commit()
+}
+
+// RemoveStaleTempFiles removes temporary files left behind for path by
+// processes that crashed before committing or aborting them. Files modified
+// within maxAge are assumed to still be in use and are kept.
+func RemoveStaleTempFiles(path string, maxAge time.Duration) error {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	prefix := tempFilePrefix(path)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !isTempFileName(name, prefix) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		if time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(filepath.Dir(path), name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func tempFilePrefix(path string) string {
+	return "." + filepath.Base(path) + "."
+}
+
+func tempFilePattern(path string) string {
+	return tempFilePrefix(path) + "*" + tempFileSuffix
+}
+
+// isTempFileName reports whether name was created from tempFilePattern with the
+// given prefix. os.CreateTemp only substitutes digits for the "*", which
+// distinguishes ".file.123.tmp" from the temp files of "file.other".
+func isTempFileName(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, tempFileSuffix) {
+		return false
+	}
+
+	random := strings.TrimSuffix(strings.TrimPrefix(name, prefix), tempFileSuffix)
+	if random == "" {
+		return false
+	}
+	for _, r := range random {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories cannot be opened for syncing on Windows.
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
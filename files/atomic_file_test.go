@@ -0,0 +1,139 @@
+package files_test
+
+import (
+	"github.com/mdelillo/go-utils/files"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAtomicFile(t *testing.T) {
+	spec.Run(t, "AtomicFile", testAtomicFile, spec.Report(report.Terminal{}))
+}
+
+func testAtomicFile(t *testing.T, context spec.G, it spec.S) {
+	var (
+		tempDir string
+		path    string
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		tempDir = t.TempDir()
+		path = filepath.Join(tempDir, "some-file")
+	})
+
+	context("CreateAtomic", func() {
+		it("only writes to the path once committed", func() {
+			require.NoError(os.WriteFile(path, []byte("old-contents"), 0644))
+
+			file, err := files.CreateAtomic(path, 0600)
+			require.NoError(err)
+			assert.Equal(tempDir, filepath.Dir(file.Name()))
+			assert.Equal(path, file.Path())
+
+			_, err = file.Write([]byte("new-contents"))
+			require.NoError(err)
+
+			contents, err := os.ReadFile(path)
+			require.NoError(err)
+			assert.Equal("old-contents", string(contents))
+
+			require.NoError(file.Commit())
+
+			contents, err = os.ReadFile(path)
+			require.NoError(err)
+			assert.Equal("new-contents", string(contents))
+
+			info, err := os.Stat(path)
+			require.NoError(err)
+			assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+			assert.NoFileExists(file.Name())
+			assert.NoError(file.Abort())
+		})
+
+		it("applies the umask to the permissions", func() {
+			otherPath := filepath.Join(tempDir, "some-other-file")
+			require.NoError(os.WriteFile(otherPath, nil, 0666))
+			expectedInfo, err := os.Stat(otherPath)
+			require.NoError(err)
+
+			file, err := files.CreateAtomic(path, 0666)
+			require.NoError(err)
+			require.NoError(file.Commit())
+
+			info, err := os.Stat(path)
+			require.NoError(err)
+			assert.Equal(expectedInfo.Mode().Perm(), info.Mode().Perm())
+		})
+
+		it("removes the temp file when aborted", func() {
+			file, err := files.CreateAtomic(path, 0644)
+			require.NoError(err)
+
+			_, err = file.Write([]byte("some-contents"))
+			require.NoError(err)
+
+			require.NoError(file.Abort())
+
+			assert.NoFileExists(file.Name())
+			assert.NoFileExists(path)
+			assert.Error(file.Commit())
+		})
+
+		it("can commit to a different path", func() {
+			otherPath := filepath.Join(tempDir, "some-other-file")
+
+			file, err := files.CreateAtomic(path, 0644)
+			require.NoError(err)
+
+			require.NoError(file.CommitAs(otherPath))
+
+			assert.NoFileExists(path)
+			assert.FileExists(otherPath)
+		})
+	})
+
+	context("WriteFile", func() {
+		it("writes the file", func() {
+			require.NoError(files.WriteFile(path, []byte("some-contents"), 0644))
+
+			contents, err := os.ReadFile(path)
+			require.NoError(err)
+			assert.Equal("some-contents", string(contents))
+
+			entries, err := os.ReadDir(tempDir)
+			require.NoError(err)
+			assert.Len(entries, 1)
+		})
+	})
+
+	context("RemoveStaleTempFiles", func() {
+		it("removes old temp files for the path", func() {
+			staleFile := filepath.Join(tempDir, ".some-file.123.tmp")
+			recentFile := filepath.Join(tempDir, ".some-file.456.tmp")
+			otherFile := filepath.Join(tempDir, ".some-file.other.789.tmp")
+			for _, file := range []string{staleFile, recentFile, otherFile} {
+				require.NoError(os.WriteFile(file, nil, 0644))
+			}
+
+			oldTime := time.Now().Add(-time.Hour)
+			require.NoError(os.Chtimes(staleFile, oldTime, oldTime))
+			require.NoError(os.Chtimes(otherFile, oldTime, oldTime))
+
+			require.NoError(files.RemoveStaleTempFiles(path, time.Minute))
+
+			assert.NoFileExists(staleFile)
+			assert.FileExists(recentFile)
+			assert.FileExists(otherFile)
+		})
+	})
+}
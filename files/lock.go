@@ -0,0 +1,41 @@
+package files
+
+import (
+	"fmt"
+	"os"
+)
+
+// Lock is an exclusive lock on a file which is held across processes. Locks
+// are advisory, so they only keep out other processes which also lock the
+// file.
+type Lock struct {
+	file *os.File
+}
+
+// LockFile blocks until it holds the lock on path, creating the file if it
+// does not exist. The file is left in place when the lock is released, as
+// removing it would let two processes lock different files at the same path.
+func LockFile(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to lock file: %w", err)
+	}
+
+	return &Lock{file: file}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	unlockErr := unlockFile(l.file)
+	closeErr := l.file.Close()
+
+	if unlockErr != nil {
+		return fmt.Errorf("failed to unlock file: %w", unlockErr)
+	}
+	return closeErr
+}
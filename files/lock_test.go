@@ -0,0 +1,59 @@
+package files_test
+
+import (
+	"github.com/mdelillo/go-utils/files"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	spec.Run(t, "Lock", testLock, spec.Report(report.Terminal{}))
+}
+
+func testLock(t *testing.T, context spec.G, it spec.S) {
+	var (
+		path string
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		path = filepath.Join(t.TempDir(), "some-file.lock")
+	})
+
+	context("LockFile", func() {
+		it("blocks until the lock is released", func() {
+			lock, err := files.LockFile(path)
+			require.NoError(err)
+			assert.FileExists(path)
+
+			locked := make(chan *files.Lock)
+			go func() {
+				otherLock, err := files.LockFile(path)
+				assert.NoError(err)
+				locked <- otherLock
+			}()
+
+			select {
+			case <-locked:
+				t.Fatal("expected the second lock to wait for the first")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			require.NoError(lock.Unlock())
+
+			select {
+			case otherLock := <-locked:
+				require.NoError(otherLock.Unlock())
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the second lock")
+			}
+		})
+	})
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package files
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+var (
+	umaskOnce sync.Once
+	umaskMode os.FileMode
+)
+
+// umask returns the file mode creation mask of the process. It is only read
+// once, since reading it means briefly replacing it.
+func umask() os.FileMode {
+	umaskOnce.Do(func() {
+		mask := syscall.Umask(0)
+		syscall.Umask(mask)
+		umaskMode = os.FileMode(mask)
+	})
+	return umaskMode
+}
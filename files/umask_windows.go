@@ -0,0 +1,10 @@
+//go:build windows
+
+package files
+
+import "os"
+
+// umask returns 0, since Windows has no file mode creation mask.
+func umask() os.FileMode {
+	return 0
+}
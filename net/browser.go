@@ -1,10 +1,13 @@
 package net
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,6 +27,12 @@ type Browser struct {
 	Headers     map[string]string
 	RateLimiter RateLimiter
 	Retrier     Retrier
+	// Robots, if set, makes the browser comply with robots.txt files.
+	Robots *RobotsPolicy
+
+	// rateLimiterMu serializes access to rate limiters, which are not safe for
+	// concurrent use, when the browser is shared between goroutines.
+	rateLimiterMu sync.Mutex
 }
 
 func NewBrowser(options ...BrowserOption) *Browser {
@@ -84,8 +93,13 @@ func WithDefaultRateLimiter(rateLimiter RateLimiter) func(*Browser) {
 }
 
 type requestOptions struct {
+	ctx         context.Context
 	rateLimiter RateLimiter
 	retrier     Retrier
+	onAttempt   func(attempt int)
+	sameSite    *SameSiteContext
+
+	onUploadProgress UploadProgressCallback
 }
 
 type RequestOption func(r *http.Request, opts *requestOptions)
@@ -146,6 +160,43 @@ func WithRetrier(retrier Retrier) func(_ *http.Request, opts *requestOptions) {
 	}
 }
 
+// WithContext makes the request with ctx, which cancels it along with any
+// wait for the rate limiter or retrier.
+func WithContext(ctx context.Context) func(_ *http.Request, opts *requestOptions) {
+	return func(_ *http.Request, opts *requestOptions) {
+		opts.ctx = ctx
+	}
+}
+
+// WithNavigation makes the request as a top-level navigation from the page at
+// initiator, like following a link, so that the cookie jar applies SameSite
+// rules to it. A nil initiator is a navigation the user started, like typing
+// the URL, which is same-site. It has no effect unless the client's jar
+// supports SameSite, like a PersistableCookieJar.
+func WithNavigation(initiator *url.URL) func(_ *http.Request, opts *requestOptions) {
+	return func(_ *http.Request, opts *requestOptions) {
+		opts.sameSite = &SameSiteContext{Initiator: initiator, Navigation: true}
+	}
+}
+
+// WithSubresource makes the request for a subresource of the page at
+// initiator, like an image or a script, so that the cookie jar applies
+// SameSite rules to it. It has no effect unless the client's jar supports
+// SameSite, like a PersistableCookieJar.
+func WithSubresource(initiator *url.URL) func(_ *http.Request, opts *requestOptions) {
+	return func(_ *http.Request, opts *requestOptions) {
+		opts.sameSite = &SameSiteContext{Initiator: initiator}
+	}
+}
+
+// withAttemptHook calls onAttempt before each attempt to make the request,
+// including retries, with the number of the attempt starting from 1.
+func withAttemptHook(onAttempt func(attempt int)) func(_ *http.Request, opts *requestOptions) {
+	return func(_ *http.Request, opts *requestOptions) {
+		opts.onAttempt = onAttempt
+	}
+}
+
 func (b *Browser) Do(req *http.Request, options ...RequestOption) (*http.Response, error) {
 	b.ensureClient()
 	b.setHeaders(req)
@@ -159,11 +210,57 @@ func (b *Browser) Do(req *http.Request, options ...RequestOption) (*http.Respons
 		option(req, opts)
 	}
 
+	if opts.ctx != nil {
+		req = req.WithContext(opts.ctx)
+	}
+
+	client := b.clientFor(req, opts.sameSite)
+
+	if b.Robots != nil {
+		crawlDelayRateLimiter, err := b.Robots.check(b, req)
+		if err != nil {
+			// Like http.Client, the body is closed even if the request is not
+			// sent.
+			if req.Body != nil {
+				_ = req.Body.Close()
+			}
+			return nil, err
+		}
+
+		if crawlDelayRateLimiter != nil {
+			if opts.rateLimiter == nil {
+				opts.rateLimiter = crawlDelayRateLimiter
+			} else {
+				opts.rateLimiter = &MultiRateLimiter{RateLimiters: []RateLimiter{opts.rateLimiter, crawlDelayRateLimiter}}
+			}
+		}
+	}
+
 	var attempt int
 	for {
 		attempt++
+		if opts.onAttempt != nil {
+			opts.onAttempt(attempt)
+		}
+
+		// The body was consumed by the previous attempt, so retries send a
+		// fresh copy of it.
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to replay request body: %w", err)
+			}
+			req.Body = body
+		}
+
+		if opts.onUploadProgress != nil && req.Body != nil && req.Body != http.NoBody {
+			if progressReader, ok := req.Body.(*uploadProgressReader); ok {
+				req.Body = progressReader.body
+			}
+			req.Body = newUploadProgressReader(req, attempt, opts.onUploadProgress)
+		}
 
-		resp, err := b.doWithRateLimiter(req, opts.rateLimiter)
+		resp, err := b.doWithRateLimiter(client, req, opts.rateLimiter)
 		if err != nil {
 			return resp, err
 		}
@@ -171,7 +268,10 @@ func (b *Browser) Do(req *http.Request, options ...RequestOption) (*http.Respons
 		if opts.retrier != nil {
 			shouldRetry, backoff := opts.retrier.ShouldRetry(req, resp, attempt)
 			if shouldRetry {
-				time.Sleep(backoff)
+				_ = resp.Body.Close()
+				if err := sleepContext(req.Context(), backoff); err != nil {
+					return nil, err
+				}
 				continue
 			}
 		}
@@ -180,13 +280,72 @@ func (b *Browser) Do(req *http.Request, options ...RequestOption) (*http.Respons
 	}
 }
 
-func (b *Browser) doWithRateLimiter(req *http.Request, rateLimiter RateLimiter) (*http.Response, error) {
+// sameSiteJar is a cookie jar which can enforce SameSite attributes.
+type sameSiteJar interface {
+	InContext(c SameSiteContext) http.CookieJar
+}
+
+// clientFor returns the client to make req with. If the request was made in a
+// SameSite context and the client's jar supports it, this is a copy of the
+// client whose jar enforces SameSite attributes for the request and its
+// redirects.
+func (b *Browser) clientFor(req *http.Request, sameSite *SameSiteContext) *http.Client {
+	if sameSite == nil {
+		return b.Client
+	}
+
+	jar, ok := b.Client.Jar.(sameSiteJar)
+	if !ok {
+		return b.Client
+	}
+
+	c := *sameSite
+	c.Method = req.Method
+
+	client := *b.Client
+	client.Jar = jar.InContext(c)
+	return &client
+}
+
+func (b *Browser) doWithRateLimiter(client *http.Client, req *http.Request, rateLimiter RateLimiter) (*http.Response, error) {
 	if rateLimiter != nil {
-		time.Sleep(rateLimiter.GetBackoffAt(req, time.Now()))
-		defer rateLimiter.AddRequest(req, time.Now())
+		b.rateLimiterMu.Lock()
+		backoff := rateLimiter.GetBackoffAt(req, time.Now())
+		b.rateLimiterMu.Unlock()
+
+		if err := sleepContext(req.Context(), backoff); err != nil {
+			if req.Body != nil {
+				_ = req.Body.Close()
+			}
+			return nil, err
+		}
+
+		defer func() {
+			b.rateLimiterMu.Lock()
+			rateLimiter.AddRequest(req, time.Now())
+			b.rateLimiterMu.Unlock()
+		}()
 	}
 
-	return b.Client.Do(req)
+	return client.Do(req)
+}
+
+// sleepContext waits for duration, returning early with the error of ctx if it
+// is done first.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	if duration <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (b *Browser) Get(url string, options ...RequestOption) (resp *http.Response, err error) {
@@ -1,6 +1,8 @@
 package net_test
 
 import (
+	"bufio"
+	gocontext "context"
 	"github.com/mdelillo/go-utils/net"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
@@ -9,7 +11,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 )
 
 func TestBrowser(t *testing.T) {
@@ -435,6 +439,65 @@ func testBrowser(t *testing.T, context spec.G, it spec.S) {
 				assert.Equal(6, handler.RequestCount)
 			})
 		})
+
+		context("WithContext", func() {
+			it("stops waiting for the rate limiter when the context is cancelled", func() {
+				browser := net.NewBrowser(net.WithDefaultRateLimiter(&mockRateLimiter{getBackoffReturn: time.Hour}))
+
+				ctx, cancel := gocontext.WithCancel(gocontext.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+
+				req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+				require.NoError(err)
+				_, err = browser.Do(req, net.WithContext(ctx))
+				assert.ErrorIs(err, gocontext.Canceled)
+				assert.Equal(0, handler.RequestCount)
+			})
+
+			it("stops waiting to retry when the context is cancelled", func() {
+				browser := net.NewBrowser(net.WithDefaultRetrier(net.ExponentialBackoffRetrier{InitialBackoff: time.Hour, MaxAttempts: 2}))
+
+				ctx, cancel := gocontext.WithCancel(gocontext.Background())
+				time.AfterFunc(10*time.Millisecond, cancel)
+
+				req, err := http.NewRequest(http.MethodGet, server.URL+"/500", nil)
+				require.NoError(err)
+				_, err = browser.Do(req, net.WithContext(ctx))
+				assert.ErrorIs(err, gocontext.Canceled)
+				assert.Equal(1, handler.RequestCount)
+			})
+		})
+
+		context("WithNavigation and WithSubresource", func() {
+			it("apply SameSite rules to the cookies the jar sends", func() {
+				serverURL, err := url.Parse(server.URL)
+				require.NoError(err)
+				otherSite, err := url.Parse("http://other.com/")
+				require.NoError(err)
+
+				jar := net.NewPersistableCookieJar(nil)
+				jar.SetCookies(serverURL, []*http.Cookie{
+					{Name: "strict-cookie", Value: "some-value", SameSite: http.SameSiteStrictMode},
+					{Name: "lax-cookie", Value: "some-value", SameSite: http.SameSiteLaxMode},
+				})
+				browser := net.NewBrowser(net.WithClient(net.NewHTTPClient(net.WithCookieJar(jar))))
+
+				cookieHeader := func(options ...net.RequestOption) string {
+					resp, err := browser.Get(server.URL+"/show-request", options...)
+					require.NoError(err)
+					defer resp.Body.Close()
+
+					req, err := http.ReadRequest(bufio.NewReader(resp.Body))
+					require.NoError(err)
+					return req.Header.Get("Cookie")
+				}
+
+				assert.Equal("strict-cookie=some-value; lax-cookie=some-value", cookieHeader())
+				assert.Equal("strict-cookie=some-value; lax-cookie=some-value", cookieHeader(net.WithNavigation(serverURL)))
+				assert.Equal("lax-cookie=some-value", cookieHeader(net.WithNavigation(otherSite)))
+				assert.Equal("", cookieHeader(net.WithSubresource(otherSite)))
+			})
+		})
 	})
 
 	context("Get", func() {
@@ -0,0 +1,207 @@
+package net
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+type ChecksumAlgorithm string
+
+const (
+	SHA256 ChecksumAlgorithm = "sha256"
+	SHA512 ChecksumAlgorithm = "sha512"
+	SHA1   ChecksumAlgorithm = "sha1"
+	MD5    ChecksumAlgorithm = "md5"
+)
+
+// Checksum is an expected digest of a downloaded file. Digest is hex-encoded.
+type Checksum struct {
+	Algorithm ChecksumAlgorithm
+	Digest    string
+}
+
+type ChecksumMismatchError struct {
+	FilePath  string
+	Algorithm ChecksumAlgorithm
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch for %s: expected %s, got %s", e.Algorithm, e.FilePath, e.Expected, e.Actual)
+}
+
+// ParseChecksum parses a checksum written as "<algorithm>:<hex digest>", like
+// "sha256:9f86d0...".
+func ParseChecksum(s string) (Checksum, error) {
+	algorithm, digest, found := strings.Cut(strings.TrimSpace(s), ":")
+	if !found {
+		return Checksum{}, fmt.Errorf("expected <algorithm>:<digest>, got %q", s)
+	}
+
+	checksum := Checksum{
+		Algorithm: ChecksumAlgorithm(strings.ToLower(algorithm)),
+		Digest:    strings.ToLower(digest),
+	}
+
+	h, err := newHash(checksum.Algorithm)
+	if err != nil {
+		return Checksum{}, err
+	}
+
+	if _, err := hex.DecodeString(checksum.Digest); err != nil || len(checksum.Digest) != 2*h.Size() {
+		return Checksum{}, fmt.Errorf("invalid %s digest: %q", checksum.Algorithm, digest)
+	}
+
+	return checksum, nil
+}
+
+func newHash(algorithm ChecksumAlgorithm) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case MD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %q", algorithm)
+	}
+}
+
+// checksumVerifier hashes everything written to it with each algorithm needed
+// by its expected checksums.
+type checksumVerifier struct {
+	checksums []Checksum
+	hashes    map[ChecksumAlgorithm]hash.Hash
+}
+
+func newChecksumVerifier(checksums []Checksum) (*checksumVerifier, error) {
+	verifier := &checksumVerifier{hashes: map[ChecksumAlgorithm]hash.Hash{}}
+
+	for _, checksum := range checksums {
+		algorithm := ChecksumAlgorithm(strings.ToLower(string(checksum.Algorithm)))
+		if _, ok := verifier.hashes[algorithm]; !ok {
+			h, err := newHash(algorithm)
+			if err != nil {
+				return nil, err
+			}
+			verifier.hashes[algorithm] = h
+		}
+
+		verifier.checksums = append(verifier.checksums, Checksum{
+			Algorithm: algorithm,
+			Digest:    strings.ToLower(strings.TrimSpace(checksum.Digest)),
+		})
+	}
+
+	return verifier, nil
+}
+
+func (v *checksumVerifier) enabled() bool {
+	return len(v.checksums) > 0
+}
+
+func (v *checksumVerifier) Write(bytes []byte) (int, error) {
+	for _, h := range v.hashes {
+		_, _ = h.Write(bytes)
+	}
+	return len(bytes), nil
+}
+
+func (v *checksumVerifier) verify(filePath string) error {
+	for _, checksum := range v.checksums {
+		actual := hex.EncodeToString(v.hashes[checksum.Algorithm].Sum(nil))
+		if actual != checksum.Digest {
+			return &ChecksumMismatchError{
+				FilePath:  filePath,
+				Algorithm: checksum.Algorithm,
+				Expected:  checksum.Digest,
+				Actual:    actual,
+			}
+		}
+	}
+	return nil
+}
+
+// checksumsFromHeaders returns the digests advertised in the Digest (RFC 3230)
+// and Content-Digest (RFC 9530) headers. Unsupported algorithms are ignored.
+func checksumsFromHeaders(header http.Header) []Checksum {
+	var checksums []Checksum
+
+	for _, name := range []string{"Digest", "Content-Digest"} {
+		for _, value := range header.Values(name) {
+			for _, member := range strings.Split(value, ",") {
+				name, encodedDigest, found := strings.Cut(strings.TrimSpace(member), "=")
+				if !found {
+					continue
+				}
+
+				algorithm, ok := digestHeaderAlgorithms[strings.ToLower(name)]
+				if !ok {
+					continue
+				}
+
+				digest, err := base64.StdEncoding.DecodeString(strings.Trim(encodedDigest, ":"))
+				if err != nil {
+					continue
+				}
+
+				checksums = append(checksums, Checksum{Algorithm: algorithm, Digest: hex.EncodeToString(digest)})
+			}
+		}
+	}
+
+	return checksums
+}
+
+var digestHeaderAlgorithms = map[string]ChecksumAlgorithm{
+	"sha-256": SHA256,
+	"sha-512": SHA512,
+	"sha":     SHA1,
+	"sha-1":   SHA1,
+	"md5":     MD5,
+}
+
+// parseChecksumFile reads a sidecar checksum file, either containing only a
+// digest or in the "<digest>  <filename>" format written by sha256sum. When
+// the file lists several files, the line for fileName is used.
+func parseChecksumFile(reader io.Reader, fileName string) (string, error) {
+	var digests []string
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) > 1 && path.Base(strings.TrimPrefix(fields[1], "*")) == fileName {
+			return fields[0], nil
+		}
+
+		digests = append(digests, fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	if len(digests) != 1 {
+		return "", fmt.Errorf("no checksum found for %s", fileName)
+	}
+
+	return digests[0], nil
+}
@@ -0,0 +1,194 @@
+package net
+
+import (
+	"sort"
+	"time"
+)
+
+type JarOption func(*PersistableCookieJar)
+
+// CookieEvictionReason is why a cookie was evicted from a jar.
+type CookieEvictionReason string
+
+const (
+	// CookieEvictionExpired is the reason for expired cookies, which are
+	// evicted before any others when a limit is reached.
+	CookieEvictionExpired CookieEvictionReason = "expired"
+	// CookieEvictionDomainLimit is the reason for cookies evicted because
+	// their domain had too many cookies.
+	CookieEvictionDomainLimit CookieEvictionReason = "domain limit"
+	// CookieEvictionTotalLimit is the reason for cookies evicted because the
+	// jar had too many cookies.
+	CookieEvictionTotalLimit CookieEvictionReason = "total limit"
+)
+
+// cookieEviction is an evicted cookie, which is reported once the jar is
+// unlocked.
+type cookieEviction struct {
+	entry  JarEntry
+	reason CookieEvictionReason
+}
+
+// WithMaxCookiesPerDomain limits the number of cookies stored for each site,
+// which is a domain that can be registered along with its subdomains, like
+// "example.co.uk". RFC 6265 recommends allowing at least 50.
+func WithMaxCookiesPerDomain(max int) func(j *PersistableCookieJar) {
+	return func(j *PersistableCookieJar) {
+		j.maxCookiesPerDomain = max
+	}
+}
+
+// WithMaxCookies limits the number of cookies stored in the jar. RFC 6265
+// recommends allowing at least 3000.
+func WithMaxCookies(max int) func(j *PersistableCookieJar) {
+	return func(j *PersistableCookieJar) {
+		j.maxCookies = max
+	}
+}
+
+// WithMaxCookieSize ignores cookies whose name and value are longer than size
+// bytes in total. RFC 6265bis limits them to 4096 bytes.
+func WithMaxCookieSize(size int) func(j *PersistableCookieJar) {
+	return func(j *PersistableCookieJar) {
+		j.maxCookieSize = size
+	}
+}
+
+// WithEvictionCallback calls onEvict with each cookie evicted to keep the jar
+// within its limits. It is called after the jar is unlocked, so it can use the
+// jar.
+func WithEvictionCallback(onEvict func(e JarEntry, reason CookieEvictionReason)) func(j *PersistableCookieJar) {
+	return func(j *PersistableCookieJar) {
+		j.onEvict = onEvict
+	}
+}
+
+// tooLarge reports whether a cookie is over the size limit.
+func (j *PersistableCookieJar) tooLarge(name, value string) bool {
+	return j.maxCookieSize > 0 && len(name)+len(value) > j.maxCookieSize
+}
+
+// evict removes cookies until the submaps at keys and the whole jar are within
+// their limits. Like browsers, it evicts expired cookies first and then the
+// ones which were least recently accessed. It must be called with mu held,
+// after the change which may have exceeded the limits is reported.
+func (j *PersistableCookieJar) evict(keys []string, now time.Time) []cookieEviction {
+	var evictions []cookieEviction
+
+	if j.maxCookiesPerDomain > 0 {
+		for _, key := range keys {
+			submap := j.entries[key]
+			excess := len(submap) - j.maxCookiesPerDomain
+			if excess <= 0 {
+				continue
+			}
+
+			candidates := map[string]map[string]JarEntry{key: submap}
+			evictions = append(evictions, j.evictFrom(candidates, excess, CookieEvictionDomainLimit, now)...)
+		}
+	}
+
+	if j.maxCookies > 0 {
+		total := 0
+		for _, submap := range j.entries {
+			total += len(submap)
+		}
+		if excess := total - j.maxCookies; excess > 0 {
+			evictions = append(evictions, j.evictFrom(j.entries, excess, CookieEvictionTotalLimit, now)...)
+		}
+	}
+
+	return evictions
+}
+
+// evictImported removes the imported entries which are too large, and then
+// evicts cookies until every key and the whole jar are within their limits. It
+// reports any removals as a change, and must be called with mu held.
+func (j *PersistableCookieJar) evictImported(now time.Time) []cookieEviction {
+	removed := false
+	keys := make([]string, 0, len(j.entries))
+	for key, submap := range j.entries {
+		for id, e := range submap {
+			if j.tooLarge(e.Name, e.Value) {
+				delete(submap, id)
+				removed = true
+			}
+		}
+		if len(submap) == 0 {
+			delete(j.entries, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	evictions := j.evict(keys, now)
+	if removed || len(evictions) > 0 {
+		j.changed()
+	}
+	return evictions
+}
+
+// evictFrom removes count cookies from entries, which may be the entries of
+// the whole jar or of some of its keys.
+func (j *PersistableCookieJar) evictFrom(entries map[string]map[string]JarEntry, count int, reason CookieEvictionReason, now time.Time) []cookieEviction {
+	type candidate struct {
+		key     string
+		id      string
+		entry   JarEntry
+		expired bool
+	}
+
+	var candidates []candidate
+	for key, submap := range entries {
+		for id, e := range submap {
+			candidates = append(candidates, candidate{
+				key:     key,
+				id:      id,
+				entry:   e,
+				expired: e.Persistent && !e.Expires.After(now),
+			})
+		}
+	}
+
+	sort.Slice(candidates, func(a, b int) bool {
+		c := candidates
+		if c[a].expired != c[b].expired {
+			return c[a].expired
+		}
+		if !c[a].entry.LastAccess.Equal(c[b].entry.LastAccess) {
+			return c[a].entry.LastAccess.Before(c[b].entry.LastAccess)
+		}
+		return c[a].entry.SeqNum < c[b].entry.SeqNum
+	})
+
+	if count > len(candidates) {
+		count = len(candidates)
+	}
+
+	evictions := make([]cookieEviction, count)
+	for i, c := range candidates[:count] {
+		submap := j.entries[c.key]
+		delete(submap, c.id)
+		if len(submap) == 0 {
+			delete(j.entries, c.key)
+		}
+
+		evictions[i] = cookieEviction{entry: c.entry, reason: reason}
+		if c.expired {
+			evictions[i].reason = CookieEvictionExpired
+		}
+	}
+
+	return evictions
+}
+
+// reportEvictions calls the eviction callback. It must be called without mu
+// held.
+func (j *PersistableCookieJar) reportEvictions(evictions []cookieEviction) {
+	if j.onEvict == nil {
+		return
+	}
+	for _, eviction := range evictions {
+		j.onEvict(eviction.entry, eviction.reason)
+	}
+}
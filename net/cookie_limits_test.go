@@ -0,0 +1,198 @@
+package net_test
+
+import (
+	"fmt"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieLimits(t *testing.T) {
+	spec.Run(t, "CookieLimits", testCookieLimits, spec.Report(report.Terminal{}))
+}
+
+func testCookieLimits(t *testing.T, context spec.G, it spec.S) {
+	var (
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	parseURL := func(rawURL string) *url.URL {
+		u, err := url.Parse(rawURL)
+		require.NoError(err)
+		return u
+	}
+
+	entryNames := func(jar *net.PersistableCookieJar) []string {
+		var names []string
+		for _, e := range jar.Entries() {
+			names = append(names, e.Name)
+		}
+		return names
+	}
+
+	context("WithMaxCookiesPerDomain", func() {
+		it("evicts the least recently accessed cookies of the domain", func() {
+			jar := net.NewPersistableCookieJar(nil, net.WithMaxCookiesPerDomain(2))
+			u := parseURL("https://www.example.com/")
+
+			jar.SetCookies(u, []*http.Cookie{{Name: "cookie-1", Value: "some-value"}})
+			time.Sleep(time.Millisecond)
+			jar.SetCookies(u, []*http.Cookie{{Name: "cookie-2", Value: "some-value", Path: "/private"}})
+			time.Sleep(time.Millisecond)
+			jar.SetCookies(parseURL("https://other.com/"), []*http.Cookie{{Name: "other-cookie", Value: "some-value"}})
+
+			// cookie-1 is sent, but cookie-2 is not, so cookie-2 is evicted.
+			time.Sleep(time.Millisecond)
+			jar.Cookies(u)
+			time.Sleep(time.Millisecond)
+			jar.SetCookies(parseURL("https://api.example.com/"), []*http.Cookie{{Name: "cookie-3", Value: "some-value", Domain: "example.com"}})
+
+			assert.ElementsMatch([]string{"cookie-1", "cookie-3", "other-cookie"}, entryNames(jar))
+		})
+
+		it("evicts expired cookies first", func() {
+			jar := net.NewPersistableCookieJar(nil, net.WithMaxCookiesPerDomain(2))
+			u := parseURL("https://example.com/")
+
+			jar.SetCookies(u, []*http.Cookie{{Name: "old-cookie", Value: "some-value"}})
+			time.Sleep(time.Millisecond)
+			jar.SetCookies(u, []*http.Cookie{{Name: "expiring-cookie", Value: "some-value", Expires: time.Now().Add(50 * time.Millisecond)}})
+			time.Sleep(100 * time.Millisecond)
+			jar.SetCookies(u, []*http.Cookie{{Name: "new-cookie", Value: "some-value"}})
+
+			assert.ElementsMatch([]string{"old-cookie", "new-cookie"}, entryNames(jar))
+		})
+	})
+
+	context("WithMaxCookies", func() {
+		it("evicts the least recently accessed cookies of any domain", func() {
+			jar := net.NewPersistableCookieJar(nil, net.WithMaxCookies(2))
+
+			for i := 1; i <= 3; i++ {
+				jar.SetCookies(parseURL(fmt.Sprintf("https://example%d.com/", i)), []*http.Cookie{{Name: fmt.Sprintf("cookie-%d", i), Value: "some-value"}})
+				time.Sleep(time.Millisecond)
+			}
+
+			assert.ElementsMatch([]string{"cookie-2", "cookie-3"}, entryNames(jar))
+		})
+
+		it("applies to imported cookies", func() {
+			jar := net.NewPersistableCookieJar(nil, net.WithMaxCookies(1))
+
+			require.NoError(jar.ImportCookiesTxt(strings.NewReader(strings.Join([]string{
+				"example.com\tFALSE\t/\tFALSE\t0\tsome-cookie\tsome-value",
+				"example.org\tFALSE\t/\tFALSE\t0\tsome-other-cookie\tsome-value",
+			}, "\n"))))
+
+			assert.Len(jar.Entries(), 1)
+		})
+	})
+
+	context("Import", func() {
+		it("evicts imported cookies which are over the limits", func() {
+			source := net.NewPersistableCookieJar(nil)
+			source.SetCookies(parseURL("https://example.com/"), []*http.Cookie{{Name: "old-cookie", Value: "some-value"}})
+			time.Sleep(time.Millisecond)
+			source.SetCookies(parseURL("https://example.com/"), []*http.Cookie{
+				{Name: "new-cookie", Value: "some-value"},
+				{Name: "large-cookie", Value: strings.Repeat("x", 100)},
+			})
+
+			var evicted []string
+			jar := net.NewPersistableCookieJar(nil,
+				net.WithMaxCookies(1),
+				net.WithMaxCookieSize(50),
+				net.WithEvictionCallback(func(e net.JarEntry, _ net.CookieEvictionReason) {
+					evicted = append(evicted, e.Name)
+				}),
+			)
+			jar.Import(source.Export())
+
+			assert.Equal([]string{"new-cookie"}, entryNames(jar))
+			assert.Equal([]string{"old-cookie"}, evicted)
+		})
+
+		it("removes cookies evicted when a FileCookieJar is loaded from its file", func() {
+			path := filepath.Join(t.TempDir(), "cookies.json")
+			u := parseURL("https://example.com/")
+
+			fileJar, err := net.NewFileCookieJar(path)
+			require.NoError(err)
+			fileJar.SetCookies(u, []*http.Cookie{{Name: "old-cookie", Value: "some-value", MaxAge: 3600}})
+			time.Sleep(time.Millisecond)
+			fileJar.SetCookies(u, []*http.Cookie{{Name: "new-cookie", Value: "some-value", MaxAge: 3600}})
+			require.NoError(fileJar.Close())
+
+			limitedJar, err := net.NewFileCookieJar(path, net.WithJarOptions(net.WithMaxCookies(1)))
+			require.NoError(err)
+			assert.Equal([]string{"new-cookie"}, entryNames(limitedJar.PersistableCookieJar))
+			require.NoError(limitedJar.Close())
+
+			reloadedJar, err := net.NewFileCookieJar(path)
+			require.NoError(err)
+			assert.Equal([]string{"new-cookie"}, entryNames(reloadedJar.PersistableCookieJar))
+		})
+	})
+
+	context("WithMaxCookieSize", func() {
+		it("ignores cookies whose name and value are too long", func() {
+			jar := net.NewPersistableCookieJar(nil, net.WithMaxCookieSize(16))
+			u := parseURL("https://example.com/")
+
+			jar.SetCookies(u, []*http.Cookie{
+				{Name: "small", Value: "some-value"},
+				{Name: "large", Value: "some-longer-value"},
+			})
+
+			assert.Equal([]string{"small"}, entryNames(jar))
+		})
+	})
+
+	context("WithEvictionCallback", func() {
+		it("is called with each evicted cookie and why it was evicted", func() {
+			type eviction struct {
+				name   string
+				reason net.CookieEvictionReason
+			}
+			var evictions []eviction
+
+			var jar *net.PersistableCookieJar
+			jar = net.NewPersistableCookieJar(nil,
+				net.WithMaxCookiesPerDomain(1),
+				net.WithMaxCookies(2),
+				net.WithEvictionCallback(func(e net.JarEntry, reason net.CookieEvictionReason) {
+					// The jar is unlocked, so it can be used.
+					jar.Entries()
+					evictions = append(evictions, eviction{name: e.Name, reason: reason})
+				}),
+			)
+
+			u := parseURL("https://example.com/")
+			jar.SetCookies(u, []*http.Cookie{{Name: "expiring-cookie", Value: "some-value", Expires: time.Now().Add(50 * time.Millisecond)}})
+			time.Sleep(100 * time.Millisecond)
+			jar.SetCookies(u, []*http.Cookie{{Name: "cookie-1", Value: "some-value"}})
+			time.Sleep(time.Millisecond)
+			jar.SetCookies(u, []*http.Cookie{{Name: "cookie-2", Value: "some-value"}})
+			time.Sleep(time.Millisecond)
+			jar.SetCookies(parseURL("https://example.org/"), []*http.Cookie{{Name: "cookie-3", Value: "some-value"}})
+			time.Sleep(time.Millisecond)
+			jar.SetCookies(parseURL("https://example.net/"), []*http.Cookie{{Name: "cookie-4", Value: "some-value"}})
+
+			assert.Equal([]eviction{
+				{name: "expiring-cookie", reason: net.CookieEvictionExpired},
+				{name: "cookie-1", reason: net.CookieEvictionDomainLimit},
+				{name: "cookie-2", reason: net.CookieEvictionTotalLimit},
+			}, evictions)
+			assert.ElementsMatch([]string{"cookie-3", "cookie-4"}, entryNames(jar))
+		})
+	})
+}
@@ -0,0 +1,180 @@
+package net
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// CookieFilter selects entries in a PersistableCookieJar. Entries must match
+// every filter they are given to.
+type CookieFilter func(e JarEntry) bool
+
+// CookieDomain matches cookies for domain and its subdomains, whether they are
+// host-only or not.
+func CookieDomain(domain string) func(e JarEntry) bool {
+	domain = canonicalCookieDomain(domain)
+	return func(e JarEntry) bool {
+		return e.Domain == domain || hasDotSuffix(e.Domain, domain)
+	}
+}
+
+// CookiePath matches cookies for path and the paths below it.
+func CookiePath(path string) func(e JarEntry) bool {
+	return func(e JarEntry) bool {
+		// "/account" and "/account/" are the same directory.
+		path := strings.TrimSuffix(path, "/")
+		return strings.TrimSuffix(e.Path, "/") == path || strings.HasPrefix(e.Path, path+"/")
+	}
+}
+
+// CookieName matches cookies named name.
+func CookieName(name string) func(e JarEntry) bool {
+	return func(e JarEntry) bool {
+		return e.Name == name
+	}
+}
+
+// SecureCookies matches cookies which are only sent over HTTPS.
+func SecureCookies() func(e JarEntry) bool {
+	return func(e JarEntry) bool {
+		return e.Secure
+	}
+}
+
+// SessionCookies matches cookies without an expiry, which browsers drop when
+// they are closed.
+func SessionCookies() func(e JarEntry) bool {
+	return func(e JarEntry) bool {
+		return !e.Persistent
+	}
+}
+
+// PersistentCookies matches cookies with an expiry.
+func PersistentCookies() func(e JarEntry) bool {
+	return func(e JarEntry) bool {
+		return e.Persistent
+	}
+}
+
+// CookiesExpiredAt matches cookies which had expired by t. Session cookies
+// never expire.
+func CookiesExpiredAt(t time.Time) func(e JarEntry) bool {
+	return func(e JarEntry) bool {
+		return e.Persistent && !e.Expires.After(t)
+	}
+}
+
+// CookiesExpiringBefore matches cookies which expire before t, including ones
+// which have already expired.
+func CookiesExpiringBefore(t time.Time) func(e JarEntry) bool {
+	return func(e JarEntry) bool {
+		return e.Persistent && e.Expires.Before(t)
+	}
+}
+
+// canonicalCookieDomain returns domain in the form it is stored in entries.
+func canonicalCookieDomain(domain string) string {
+	canonical, err := canonicalHost(strings.TrimPrefix(domain, "."))
+	if err != nil {
+		return strings.ToLower(domain)
+	}
+	return canonical
+}
+
+func matchesCookieFilters(e JarEntry, filters []CookieFilter) bool {
+	for _, filter := range filters {
+		if !filter(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Entries returns the entries in the jar which match filters, ordered by
+// domain, path and name. Expired entries are included until they are purged.
+func (j *PersistableCookieJar) Entries(filters ...CookieFilter) []JarEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var entries []JarEntry
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if matchesCookieFilters(e, filters) {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	sortJarEntries(entries)
+
+	return entries
+}
+
+// sortJarEntries orders entries by domain, path and name.
+func sortJarEntries(entries []JarEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		s := entries
+		if s[i].Domain != s[j].Domain {
+			return s[i].Domain < s[j].Domain
+		}
+		if s[i].Path != s[j].Path {
+			return s[i].Path < s[j].Path
+		}
+		return s[i].Name < s[j].Name
+	})
+}
+
+// DeleteCookie removes the cookie with exactly the given domain, path and name,
+// and reports whether there was one.
+func (j *PersistableCookieJar) DeleteCookie(domain, path, name string) bool {
+	domain = canonicalCookieDomain(domain)
+	return j.DeleteCookies(func(e JarEntry) bool {
+		return e.Domain == domain && e.Path == path && e.Name == name
+	}) > 0
+}
+
+// DeleteCookies removes the cookies which match filters, and returns how many
+// were removed. Without filters, every cookie is removed.
+func (j *PersistableCookieJar) DeleteCookies(filters ...CookieFilter) int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	removed := 0
+	for key, submap := range j.entries {
+		for id, e := range submap {
+			if matchesCookieFilters(e, filters) {
+				delete(submap, id)
+				removed++
+			}
+		}
+		if len(submap) == 0 {
+			delete(j.entries, key)
+		}
+	}
+
+	if removed > 0 {
+		j.changed()
+	}
+
+	return removed
+}
+
+// ClearSite removes every cookie for domain and its subdomains, and returns
+// how many were removed.
+func (j *PersistableCookieJar) ClearSite(domain string) int {
+	return j.DeleteCookies(CookieDomain(domain))
+}
+
+// PurgeExpired removes the cookies which have expired, and returns how many
+// were removed. Expired cookies are never sent, but they are otherwise only
+// removed from the jar when a request is made to their domain.
+func (j *PersistableCookieJar) PurgeExpired() int {
+	return j.DeleteCookies(CookiesExpiredAt(time.Now()))
+}
+
+// EndSession removes session cookies, like a browser being closed, and
+// returns how many were removed.
+func (j *PersistableCookieJar) EndSession() int {
+	return j.DeleteCookies(SessionCookies())
+}
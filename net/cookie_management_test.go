@@ -0,0 +1,156 @@
+package net_test
+
+import (
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieManagement(t *testing.T) {
+	spec.Run(t, "CookieManagement", testCookieManagement, spec.Report(report.Terminal{}))
+}
+
+func testCookieManagement(t *testing.T, context spec.G, it spec.S) {
+	var (
+		jar *net.PersistableCookieJar
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	setCookies := func(rawURL string, cookies ...*http.Cookie) {
+		u, err := url.Parse(rawURL)
+		require.NoError(err)
+		jar.SetCookies(u, cookies)
+	}
+
+	entryIDs := func(entries []net.JarEntry) []string {
+		var ids []string
+		for _, e := range entries {
+			ids = append(ids, strings.Join([]string{e.Domain, e.Path, e.Name}, ";"))
+		}
+		return ids
+	}
+
+	it.Before(func() {
+		jar = net.NewPersistableCookieJar(nil)
+
+		setCookies("https://example.com/",
+			&http.Cookie{Name: "session", Value: "some-value"},
+			&http.Cookie{Name: "persistent", Value: "some-value", MaxAge: 3600, Secure: true},
+			&http.Cookie{Name: "expiring", Value: "some-value", Expires: time.Now().Add(time.Second)},
+		)
+		setCookies("https://www.example.com/account/settings",
+			&http.Cookie{Name: "account", Value: "some-value", Path: "/account"},
+			&http.Cookie{Name: "settings", Value: "some-value"},
+		)
+		setCookies("https://other.example.org/",
+			&http.Cookie{Name: "session", Value: "some-value", Domain: "example.org"},
+		)
+	})
+
+	context("Entries", func() {
+		it("returns every entry without filters", func() {
+			assert.Equal([]string{
+				"example.com;/;expiring",
+				"example.com;/;persistent",
+				"example.com;/;session",
+				"example.org;/;session",
+				"www.example.com;/account;account",
+				"www.example.com;/account;settings",
+			}, entryIDs(jar.Entries()))
+		})
+
+		it("returns the entries which match every filter", func() {
+			assert.Equal([]string{
+				"example.com;/;expiring",
+				"example.com;/;persistent",
+				"example.com;/;session",
+				"www.example.com;/account;account",
+				"www.example.com;/account;settings",
+			}, entryIDs(jar.Entries(net.CookieDomain("example.com"))))
+
+			assert.Equal([]string{
+				"www.example.com;/account;account",
+				"www.example.com;/account;settings",
+			}, entryIDs(jar.Entries(net.CookieDomain(".WWW.Example.com"))))
+
+			assert.Equal([]string{
+				"www.example.com;/account;account",
+				"www.example.com;/account;settings",
+			}, entryIDs(jar.Entries(net.CookiePath("/account/"))))
+			assert.Empty(jar.Entries(net.CookiePath("/acc")))
+
+			assert.Equal([]string{
+				"example.com;/;session",
+				"example.org;/;session",
+			}, entryIDs(jar.Entries(net.CookieName("session"))))
+
+			assert.Equal([]string{"example.com;/;persistent"}, entryIDs(jar.Entries(net.SecureCookies())))
+
+			assert.Equal([]string{
+				"example.com;/;expiring",
+				"example.com;/;persistent",
+			}, entryIDs(jar.Entries(net.PersistentCookies())))
+
+			assert.Equal([]string{"example.com;/;expiring"}, entryIDs(jar.Entries(
+				net.CookieDomain("example.com"),
+				net.CookiesExpiringBefore(time.Now().Add(time.Minute)),
+			)))
+		})
+	})
+
+	context("DeleteCookie", func() {
+		it("removes only the cookie with the domain, path and name", func() {
+			assert.True(jar.DeleteCookie("www.example.com", "/account", "account"))
+			assert.False(jar.DeleteCookie("www.example.com", "/", "settings"))
+
+			assert.Equal([]string{"www.example.com;/account;settings"}, entryIDs(jar.Entries(net.CookieDomain("www.example.com"))))
+		})
+	})
+
+	context("DeleteCookies", func() {
+		it("removes the cookies which match the filters", func() {
+			assert.Equal(2, jar.DeleteCookies(net.CookieName("session")))
+
+			assert.Empty(jar.Entries(net.CookieName("session")))
+			assert.Len(jar.Entries(), 4)
+		})
+	})
+
+	context("ClearSite", func() {
+		it("removes the cookies for the domain and its subdomains", func() {
+			assert.Equal(5, jar.ClearSite("example.com"))
+
+			assert.Equal([]string{"example.org;/;session"}, entryIDs(jar.Entries()))
+		})
+	})
+
+	context("PurgeExpired", func() {
+		it("removes the cookies which have expired", func() {
+			assert.Equal(0, jar.PurgeExpired())
+
+			time.Sleep(1100 * time.Millisecond)
+			assert.Equal(1, jar.PurgeExpired())
+			assert.Empty(jar.Entries(net.CookieName("expiring")))
+		})
+	})
+
+	context("EndSession", func() {
+		it("removes session cookies", func() {
+			assert.Equal(4, jar.EndSession())
+
+			assert.Equal([]string{
+				"example.com;/;expiring",
+				"example.com;/;persistent",
+			}, entryIDs(jar.Entries()))
+		})
+	})
+}
@@ -0,0 +1,168 @@
+package net
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SameSiteContext is how a request was made, which decides whether cookies
+// with a SameSite attribute are sent with it and can be set by its response.
+type SameSiteContext struct {
+	// Initiator is the URL of the page which made the request. It is nil for
+	// requests the user made directly, like typing a URL, which are always
+	// same-site.
+	Initiator *url.URL
+	// Navigation is whether the request is a top-level navigation, like
+	// following a link, rather than for a subresource of the initiator, like
+	// an image or a script.
+	Navigation bool
+	// Method is the method of the request, which defaults to GET. Lax cookies
+	// are only sent with cross-site navigations with safe methods.
+	Method string
+}
+
+// WithLaxByDefault enforces cookies without a valid SameSite attribute as
+// though they were SameSite=Lax, like Chrome does, rather than
+// SameSite=None.
+func WithLaxByDefault() func(j *PersistableCookieJar) {
+	return func(j *PersistableCookieJar) {
+		j.laxByDefault = true
+	}
+}
+
+// InContext returns a jar which shares the cookies of j, but enforces their
+// SameSite attributes for requests made in c. The jar itself does not enforce
+// them, as it does not know how requests are made.
+func (j *PersistableCookieJar) InContext(c SameSiteContext) http.CookieJar {
+	return &sameSiteCookieJar{jar: j, sameSite: c}
+}
+
+type sameSiteCookieJar struct {
+	jar      *PersistableCookieJar
+	sameSite SameSiteContext
+}
+
+func (j *sameSiteCookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.cookies(u, &j.sameSite, time.Now())
+}
+
+func (j *sameSiteCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.setCookies(u, cookies, &j.sameSite, time.Now())
+}
+
+// sameSiteAttribute returns the SameSite attribute of c as it is stored in
+// entries.
+func sameSiteAttribute(c *http.Cookie) string {
+	switch c.SameSite {
+	case http.SameSiteDefaultMode:
+		return "SameSite"
+	case http.SameSiteStrictMode:
+		return "SameSite=Strict"
+	case http.SameSiteLaxMode:
+		return "SameSite=Lax"
+	case http.SameSiteNoneMode:
+		return "SameSite=None"
+	}
+	return ""
+}
+
+// enforcedSameSite returns the mode that is enforced for a stored SameSite
+// attribute. Cookies without a valid one are treated as SameSite=None, unless
+// the jar is lax by default.
+func (j *PersistableCookieJar) enforcedSameSite(attribute string) http.SameSite {
+	switch attribute {
+	case "SameSite=Strict":
+		return http.SameSiteStrictMode
+	case "SameSite=Lax":
+		return http.SameSiteLaxMode
+	case "SameSite=None":
+		return http.SameSiteNoneMode
+	}
+	if j.laxByDefault {
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteNoneMode
+}
+
+// crossSite reports whether a request to u made in c is cross-site. Sites are
+// schemeful, as in RFC 6265bis: pages on http://example.com and
+// https://example.com are on different sites.
+func (j *PersistableCookieJar) crossSite(u *url.URL, c *SameSiteContext) bool {
+	if c == nil || c.Initiator == nil {
+		return false
+	}
+
+	site, ok := j.site(u)
+	if !ok {
+		return true
+	}
+	initiatorSite, ok := j.site(c.Initiator)
+	if !ok {
+		return true
+	}
+	return site != initiatorSite
+}
+
+// site returns the scheme and registrable domain of u.
+func (j *PersistableCookieJar) site(u *url.URL) (string, bool) {
+	host, err := canonicalHost(u.Host)
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme) + "://" + jarKey(host, j.psList), true
+}
+
+// sendCrossSite reports whether e is sent with a cross-site request made in c.
+func (j *PersistableCookieJar) sendCrossSite(e JarEntry, c *SameSiteContext) bool {
+	switch j.enforcedSameSite(e.SameSite) {
+	case http.SameSiteStrictMode:
+		return false
+	case http.SameSiteLaxMode:
+		return c.Navigation && isSafeMethod(c.Method)
+	default:
+		return true
+	}
+}
+
+// isSafeMethod reports whether method is safe, as defined by RFC 7231 section
+// 4.2.1.
+func isSafeMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+const (
+	securePrefix = "__Secure-"
+	hostPrefix   = "__Host-"
+)
+
+// validCookie reports whether c, set in a response from a URL with the given
+// scheme, meets the requirements of RFC 6265bis on its name prefix and its
+// SameSite attribute:
+//   - Cookies named with a __Secure- prefix must be secure and set over HTTPS.
+//   - Cookies named with a __Host- prefix must also have no domain attribute
+//     and a path of "/", so they are only sent to the host which set them.
+//   - SameSite=None cookies must be secure.
+func validCookie(c *http.Cookie, https bool) bool {
+	if hasPrefixFold(c.Name, securePrefix) || hasPrefixFold(c.Name, hostPrefix) {
+		if !c.Secure || !https {
+			return false
+		}
+	}
+	if hasPrefixFold(c.Name, hostPrefix) && (c.Domain != "" || c.Path != "/") {
+		return false
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return false
+	}
+	return true
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
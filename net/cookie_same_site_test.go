@@ -0,0 +1,136 @@
+package net_test
+
+import (
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCookieSameSite(t *testing.T) {
+	spec.Run(t, "CookieSameSite", testCookieSameSite, spec.Report(report.Terminal{}))
+}
+
+func testCookieSameSite(t *testing.T, context spec.G, it spec.S) {
+	var (
+		jar *net.PersistableCookieJar
+		u   *url.URL
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	parseURL := func(rawURL string) *url.URL {
+		parsed, err := url.Parse(rawURL)
+		require.NoError(err)
+		return parsed
+	}
+
+	cookieNames := func(cookies []*http.Cookie) []string {
+		var names []string
+		for _, cookie := range cookies {
+			names = append(names, cookie.Name)
+		}
+		return names
+	}
+
+	it.Before(func() {
+		jar = net.NewPersistableCookieJar(nil)
+		u = parseURL("https://www.example.com/")
+	})
+
+	context("InContext", func() {
+		it.Before(func() {
+			jar.SetCookies(u, []*http.Cookie{
+				{Name: "strict-cookie", Value: "some-value", SameSite: http.SameSiteStrictMode},
+				{Name: "lax-cookie", Value: "some-value", SameSite: http.SameSiteLaxMode},
+				{Name: "none-cookie", Value: "some-value", SameSite: http.SameSiteNoneMode, Secure: true},
+				{Name: "default-cookie", Value: "some-value"},
+			})
+		})
+
+		it("sends every cookie with same-site requests", func() {
+			allCookies := []string{"strict-cookie", "lax-cookie", "none-cookie", "default-cookie"}
+
+			sameSite := jar.InContext(net.SameSiteContext{Initiator: parseURL("https://api.example.com/page")})
+			assert.ElementsMatch(allCookies, cookieNames(sameSite.Cookies(u)))
+
+			typed := jar.InContext(net.SameSiteContext{Navigation: true})
+			assert.ElementsMatch(allCookies, cookieNames(typed.Cookies(u)))
+		})
+
+		it("sends lax cookies with cross-site navigations with safe methods", func() {
+			initiator := parseURL("https://other.com/")
+
+			navigation := jar.InContext(net.SameSiteContext{Initiator: initiator, Navigation: true})
+			assert.ElementsMatch([]string{"lax-cookie", "none-cookie", "default-cookie"}, cookieNames(navigation.Cookies(u)))
+
+			post := jar.InContext(net.SameSiteContext{Initiator: initiator, Navigation: true, Method: http.MethodPost})
+			assert.ElementsMatch([]string{"none-cookie", "default-cookie"}, cookieNames(post.Cookies(u)))
+		})
+
+		it("only sends SameSite=None cookies with cross-site subresource requests", func() {
+			subresource := jar.InContext(net.SameSiteContext{Initiator: parseURL("https://other.com/")})
+			assert.ElementsMatch([]string{"none-cookie", "default-cookie"}, cookieNames(subresource.Cookies(u)))
+		})
+
+		it("treats sites with different schemes as cross-site", func() {
+			subresource := jar.InContext(net.SameSiteContext{Initiator: parseURL("http://www.example.com/")})
+			assert.ElementsMatch([]string{"none-cookie", "default-cookie"}, cookieNames(subresource.Cookies(u)))
+		})
+
+		it("does not let cross-site subresources set SameSite cookies", func() {
+			subresource := jar.InContext(net.SameSiteContext{Initiator: parseURL("https://other.com/")})
+			subresource.SetCookies(u, []*http.Cookie{
+				{Name: "new-lax-cookie", Value: "some-value", SameSite: http.SameSiteLaxMode},
+				{Name: "new-none-cookie", Value: "some-value", SameSite: http.SameSiteNoneMode, Secure: true},
+			})
+
+			names := cookieNames(jar.Cookies(u))
+			assert.NotContains(names, "new-lax-cookie")
+			assert.Contains(names, "new-none-cookie")
+		})
+
+		context("WithLaxByDefault", func() {
+			it("treats cookies without a SameSite attribute as lax", func() {
+				jar = net.NewPersistableCookieJar(nil, net.WithLaxByDefault())
+				jar.SetCookies(u, []*http.Cookie{
+					{Name: "default-cookie", Value: "some-value"},
+					{Name: "none-cookie", Value: "some-value", SameSite: http.SameSiteNoneMode, Secure: true},
+				})
+
+				subresource := jar.InContext(net.SameSiteContext{Initiator: parseURL("https://other.com/")})
+				assert.Equal([]string{"none-cookie"}, cookieNames(subresource.Cookies(u)))
+
+				navigation := jar.InContext(net.SameSiteContext{Initiator: parseURL("https://other.com/"), Navigation: true})
+				assert.ElementsMatch([]string{"default-cookie", "none-cookie"}, cookieNames(navigation.Cookies(u)))
+			})
+		})
+	})
+
+	it("rejects SameSite=None cookies which are not secure", func() {
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-value", SameSite: http.SameSiteNoneMode}})
+
+		assert.Empty(jar.Cookies(u))
+	})
+
+	it("rejects cookies which break the requirements of their name prefix", func() {
+		jar.SetCookies(u, []*http.Cookie{
+			{Name: "__Secure-valid", Value: "some-value", Secure: true},
+			{Name: "__Secure-insecure", Value: "some-value"},
+			{Name: "__Host-valid", Value: "some-value", Secure: true, Path: "/"},
+			{Name: "__Host-no-path", Value: "some-value", Secure: true},
+			{Name: "__Host-domain", Value: "some-value", Secure: true, Path: "/", Domain: "example.com"},
+			{Name: "__host-insecure", Value: "some-value", Path: "/"},
+		})
+		jar.SetCookies(parseURL("http://www.example.com/"), []*http.Cookie{
+			{Name: "__Secure-over-http", Value: "some-value", Secure: true},
+		})
+
+		assert.ElementsMatch([]string{"__Secure-valid", "__Host-valid"}, cookieNames(jar.Cookies(u)))
+	})
+}
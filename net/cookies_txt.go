@@ -0,0 +1,249 @@
+package net
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cookiesTxtHeader is the first line of cookies.txt files. Some tools refuse
+// to read files without it.
+const cookiesTxtHeader = "# Netscape HTTP Cookie File"
+
+// httpOnlyPrefix marks the domain of HttpOnly cookies in cookies.txt files,
+// which would otherwise look like comments to tools that do not support them.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// ImportCookiesTxt adds the cookies in a Netscape cookies.txt file, as written
+// by browser extensions and curl, to the jar. Cookies with an expiry of 0 are
+// session cookies, and cookies which have already expired are ignored, as are
+// cookies with domains the jar would not accept from a response, such as
+// domain cookies for public suffixes like "co.uk" or for IP addresses.
+func (j *PersistableCookieJar) ImportCookiesTxt(reader io.Reader) error {
+	return j.importCookiesTxt(reader, time.Now())
+}
+
+func (j *PersistableCookieJar) importCookiesTxt(reader io.Reader, now time.Time) error {
+	var entries []JarEntry
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		} else if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		e, err := parseCookiesTxtLine(line)
+		if err != nil {
+			return fmt.Errorf("invalid cookie on line %d: %w", lineNumber, err)
+		}
+		e.HttpOnly = httpOnly
+
+		if e.Persistent && !e.Expires.After(now) {
+			continue
+		}
+		if !j.validImportedDomain(e) {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read cookies: %w", err)
+	}
+
+	var evictions []cookieEviction
+	defer func() { j.reportEvictions(evictions) }()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.entries == nil {
+		j.entries = make(map[string]map[string]JarEntry)
+	}
+
+	var keys []string
+	for _, e := range entries {
+		if j.tooLarge(e.Name, e.Value) {
+			continue
+		}
+
+		key := jarKey(e.Domain, j.psList)
+		keys = append(keys, key)
+		submap := j.entries[key]
+		if submap == nil {
+			submap = make(map[string]JarEntry)
+			j.entries[key] = submap
+		}
+
+		id := e.id()
+		if old, ok := submap[id]; ok {
+			e.Creation = old.Creation
+			e.SeqNum = old.SeqNum
+		} else {
+			e.Creation = now
+			e.SeqNum = j.nextSeqNum
+			j.nextSeqNum++
+		}
+		e.LastAccess = now
+		submap[id] = e
+	}
+	if len(keys) > 0 {
+		j.changed()
+		evictions = j.evict(keys, now)
+	}
+
+	return nil
+}
+
+// validImportedDomain reports whether the domain of an imported cookie passes
+// the same checks as the domain attribute of a cookie set by a response for
+// that domain.
+func (j *PersistableCookieJar) validImportedDomain(e JarEntry) bool {
+	if e.HostOnly {
+		return e.Domain[0] != '.' && e.Domain[len(e.Domain)-1] != '.'
+	}
+
+	// domainAndType only turns a cookie into a host cookie when its domain is
+	// a public suffix, which domain cookies must not be set for.
+	_, hostOnly, err := j.domainAndType(e.Domain, "."+e.Domain)
+	return err == nil && !hostOnly
+}
+
+// parseCookiesTxtLine parses the tab-separated fields of a cookie: domain,
+// whether subdomains are included, path, whether it is secure, expiry as a
+// Unix time, name and value.
+func parseCookiesTxtLine(line string) (JarEntry, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) == 6 {
+		// Some tools leave out the tab before an empty value.
+		fields = append(fields, "")
+	}
+	if len(fields) != 7 {
+		return JarEntry{}, fmt.Errorf("expected 7 tab-separated fields, got %d", len(fields))
+	}
+
+	domain, isASCII := toLowerASCII(strings.TrimPrefix(fields[0], "."))
+	if domain == "" || !isASCII {
+		return JarEntry{}, fmt.Errorf("invalid domain %q", fields[0])
+	}
+
+	includeSubdomains, err := parseCookiesTxtBool(fields[1])
+	if err != nil {
+		return JarEntry{}, err
+	}
+
+	path := fields[2]
+	if path == "" || path[0] != '/' {
+		return JarEntry{}, fmt.Errorf("invalid path %q", path)
+	}
+
+	secure, err := parseCookiesTxtBool(fields[3])
+	if err != nil {
+		return JarEntry{}, err
+	}
+
+	expiry, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return JarEntry{}, fmt.Errorf("invalid expiry %q", fields[4])
+	}
+
+	e := JarEntry{
+		Name:     fields[5],
+		Value:    fields[6],
+		Domain:   domain,
+		Path:     path,
+		Secure:   secure,
+		HostOnly: !includeSubdomains,
+	}
+	if expiry == 0 {
+		e.Expires = endOfTime
+	} else {
+		e.Expires = time.Unix(expiry, 0).UTC()
+		e.Persistent = true
+	}
+
+	return e, nil
+}
+
+func parseCookiesTxtBool(value string) (bool, error) {
+	switch strings.ToUpper(value) {
+	case "TRUE":
+		return true, nil
+	case "FALSE":
+		return false, nil
+	default:
+		return false, fmt.Errorf(`expected "TRUE" or "FALSE", got %q`, value)
+	}
+}
+
+// ExportCookiesTxt writes the cookies in the jar in the Netscape cookies.txt
+// format, which can be read by curl and imported into browsers. Session
+// cookies are written with an expiry of 0, and expired cookies are left out.
+// The SameSite attribute of cookies cannot be represented, so it is lost.
+func (j *PersistableCookieJar) ExportCookiesTxt(writer io.Writer) error {
+	return j.exportCookiesTxt(writer, time.Now())
+}
+
+func (j *PersistableCookieJar) exportCookiesTxt(writer io.Writer, now time.Time) error {
+	j.mu.Lock()
+	var entries []JarEntry
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if e.Persistent && !e.Expires.After(now) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	j.mu.Unlock()
+
+	sortJarEntries(entries)
+
+	buffered := bufio.NewWriter(writer)
+	_, _ = fmt.Fprintf(buffered, "%s\n\n", cookiesTxtHeader)
+	for _, e := range entries {
+		domain := e.Domain
+		if !e.HostOnly {
+			// A leading dot is how older tools tell domain cookies apart.
+			domain = "." + domain
+		}
+		if e.HttpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+
+		var expiry int64
+		if e.Persistent {
+			expiry = e.Expires.Unix()
+		}
+
+		_, _ = fmt.Fprintf(buffered, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain,
+			formatCookiesTxtBool(!e.HostOnly),
+			e.Path,
+			formatCookiesTxtBool(e.Secure),
+			expiry,
+			e.Name,
+			e.Value,
+		)
+	}
+
+	return buffered.Flush()
+}
+
+func formatCookiesTxtBool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
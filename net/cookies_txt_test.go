@@ -0,0 +1,158 @@
+package net_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"golang.org/x/net/publicsuffix"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookiesTxt(t *testing.T) {
+	spec.Run(t, "CookiesTxt", testCookiesTxt, spec.Report(report.Terminal{}))
+}
+
+func testCookiesTxt(t *testing.T, context spec.G, it spec.S) {
+	var (
+		jar *net.PersistableCookieJar
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		jar = net.NewPersistableCookieJar(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	})
+
+	cookieNames := func(rawURL string) []string {
+		u, err := url.Parse(rawURL)
+		require.NoError(err)
+
+		var names []string
+		for _, cookie := range jar.Cookies(u) {
+			names = append(names, cookie.Name+"="+cookie.Value)
+		}
+		return names
+	}
+
+	context("ImportCookiesTxt", func() {
+		it("adds the cookies to the jar", func() {
+			expiry := time.Now().Add(time.Hour).Unix()
+			err := jar.ImportCookiesTxt(strings.NewReader(strings.Join([]string{
+				"# Netscape HTTP Cookie File",
+				"# This is a comment",
+				"",
+				fmt.Sprintf(".example.com\tTRUE\t/\tFALSE\t%d\tdomain-cookie\tsome-value", expiry),
+				"www.example.com\tFALSE\t/\tFALSE\t0\thost-cookie\tsome-other-value",
+				fmt.Sprintf("#HttpOnly_example.com\tFALSE\t/\tTRUE\t%d\thttp-only-cookie\tsome-secret", expiry),
+				"www.example.com\tFALSE\t/private\tFALSE\t0\tpath-cookie\tsome-path-value",
+				"www.example.com\tFALSE\t/\tFALSE\t1\texpired-cookie\tsome-expired-value",
+			}, "\n")))
+			require.NoError(err)
+
+			assert.ElementsMatch([]string{"domain-cookie=some-value", "host-cookie=some-other-value"}, cookieNames("http://www.example.com/"))
+			assert.ElementsMatch([]string{"domain-cookie=some-value", "host-cookie=some-other-value", "path-cookie=some-path-value"}, cookieNames("http://www.example.com/private/page"))
+			assert.ElementsMatch([]string{"domain-cookie=some-value"}, cookieNames("http://api.example.com/"))
+			assert.ElementsMatch([]string{"domain-cookie=some-value", "http-only-cookie=some-secret"}, cookieNames("https://example.com/"))
+
+			var httpOnly net.JarEntry
+			for _, entries := range jar.Export() {
+				for _, entry := range entries {
+					if entry.Name == "http-only-cookie" {
+						httpOnly = entry
+					}
+				}
+			}
+			assert.True(httpOnly.HttpOnly)
+			assert.True(httpOnly.HostOnly)
+			assert.True(httpOnly.Persistent)
+			assert.Equal(expiry, httpOnly.Expires.Unix())
+		})
+
+		it("accepts lines without a tab before an empty value", func() {
+			require.NoError(jar.ImportCookiesTxt(strings.NewReader("example.com\tFALSE\t/\tFALSE\t0\tempty-cookie\r\n")))
+
+			assert.Equal([]string{"empty-cookie="}, cookieNames("http://example.com/"))
+		})
+
+		it("ignores cookies with domains the jar would not accept", func() {
+			err := jar.ImportCookiesTxt(strings.NewReader(strings.Join([]string{
+				".com\tTRUE\t/\tFALSE\t0\ttld-cookie\tsome-value",
+				".co.uk\tTRUE\t/\tFALSE\t0\tpublic-suffix-cookie\tsome-value",
+				".127.0.0.1\tTRUE\t/\tFALSE\t0\tip-cookie\tsome-value",
+				"..example.co.uk\tTRUE\t/\tFALSE\t0\tmalformed-cookie\tsome-value",
+				"example.co.uk.\tFALSE\t/\tFALSE\t0\ttrailing-dot-cookie\tsome-value",
+				".example.co.uk\tTRUE\t/\tFALSE\t0\tdomain-cookie\tsome-value",
+				"127.0.0.1\tFALSE\t/\tFALSE\t0\thost-cookie\tsome-value",
+			}, "\n")))
+			require.NoError(err)
+
+			assert.Equal([]string{"domain-cookie=some-value"}, cookieNames("http://www.example.co.uk/"))
+			assert.Empty(cookieNames("http://other.co.uk/"))
+			assert.Empty(cookieNames("http://example.com/"))
+			assert.Equal([]string{"host-cookie=some-value"}, cookieNames("http://127.0.0.1/"))
+		})
+
+		it("returns an error with the line number of invalid cookies", func() {
+			err := jar.ImportCookiesTxt(strings.NewReader("# Netscape HTTP Cookie File\nexample.com\tMAYBE\t/\tFALSE\t0\tsome-cookie\tsome-value\n"))
+			assert.EqualError(err, `invalid cookie on line 2: expected "TRUE" or "FALSE", got "MAYBE"`)
+
+			err = jar.ImportCookiesTxt(strings.NewReader("example.com\tFALSE\t/\n"))
+			assert.EqualError(err, "invalid cookie on line 1: expected 7 tab-separated fields, got 3")
+
+			err = jar.ImportCookiesTxt(strings.NewReader("example.com\tFALSE\t/\tFALSE\tnever\tsome-cookie\tsome-value\n"))
+			assert.EqualError(err, `invalid cookie on line 1: invalid expiry "never"`)
+		})
+	})
+
+	context("ExportCookiesTxt", func() {
+		it("writes the cookies in the jar", func() {
+			expires := time.Now().Add(time.Hour).Truncate(time.Second)
+			u, err := url.Parse("https://www.example.com/")
+			require.NoError(err)
+			jar.SetCookies(u, []*http.Cookie{
+				{Name: "session-cookie", Value: "some-value"},
+				{Name: "domain-cookie", Value: "some-other-value", Domain: "example.com", Expires: expires},
+				{Name: "secure-cookie", Value: "some-secret", Secure: true, HttpOnly: true, Path: "/private"},
+			})
+
+			var output bytes.Buffer
+			require.NoError(jar.ExportCookiesTxt(&output))
+
+			assert.Equal(strings.Join([]string{
+				"# Netscape HTTP Cookie File",
+				"",
+				fmt.Sprintf(".example.com\tTRUE\t/\tFALSE\t%d\tdomain-cookie\tsome-other-value", expires.Unix()),
+				"www.example.com\tFALSE\t/\tFALSE\t0\tsession-cookie\tsome-value",
+				"#HttpOnly_www.example.com\tFALSE\t/private\tTRUE\t0\tsecure-cookie\tsome-secret",
+				"",
+			}, "\n"), output.String())
+		})
+
+		it("can be imported into another jar", func() {
+			u, err := url.Parse("https://www.example.com/")
+			require.NoError(err)
+			jar.SetCookies(u, []*http.Cookie{
+				{Name: "some-cookie", Value: "some-value", HttpOnly: true},
+				{Name: "some-other-cookie", Value: "some-other-value", Domain: "example.com", MaxAge: 3600},
+			})
+
+			var output bytes.Buffer
+			require.NoError(jar.ExportCookiesTxt(&output))
+
+			newJar := net.NewPersistableCookieJar(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+			require.NoError(newJar.ImportCookiesTxt(&output))
+
+			assert.ElementsMatch(jar.Cookies(u), newJar.Cookies(u))
+		})
+	})
+}
@@ -0,0 +1,528 @@
+package net
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultMaxCrawlBodySize is how much of each page the crawler reads by
+// default.
+const defaultMaxCrawlBodySize = 10 * 1024 * 1024
+
+// SkipLinks can be returned by Crawler.OnPage to not follow the links on a
+// page.
+var SkipLinks = errors.New("skip links")
+
+// CrawlScope limits the links a Crawler follows.
+type CrawlScope struct {
+	// Hosts are the hosts whose pages are crawled. They default to the hosts
+	// of the start URLs.
+	Hosts []string
+	// IncludeSubdomains crawls the subdomains of Hosts as well.
+	IncludeSubdomains bool
+	// PathPrefixes, if set, limits the crawl to pages whose path starts with
+	// one of them. Start URLs are crawled regardless.
+	PathPrefixes []string
+	// MaxDepth is how many links are followed away from the start URLs, or
+	// zero for no limit.
+	MaxDepth int
+	// MaxPages is how many pages are crawled, or zero for no limit.
+	MaxPages int
+}
+
+// CrawledPage is a page fetched by a Crawler.
+type CrawledPage struct {
+	// URL is the normalized URL of the page, before any redirects.
+	URL string
+	// Referrer is the URL of the page the link to this one was found on, or
+	// empty for start URLs.
+	Referrer string
+	// Depth is the number of links followed from a start URL to this page.
+	Depth int
+	// Response is the response the page was served with. Its body has been
+	// read into Body and closed.
+	Response *http.Response
+	Body     []byte
+	// Links are the normalized URLs linked to from the page, if it is HTML,
+	// whether or not they are in scope.
+	Links []string
+}
+
+// Crawler fetches pages with a Browser, starting at some URLs and following
+// the links between them that are in scope. Each URL is fetched once, after it
+// has been normalized. Rate limiting, retries and robots.txt compliance come
+// from the Browser.
+//
+// The callbacks are never called concurrently.
+type Crawler struct {
+	Browser *Browser
+	Scope   CrawlScope
+	// Concurrency is how many pages are fetched at once. It defaults to 1.
+	Concurrency int
+	// MaxBodySize is how much of each page is read. It defaults to 10 MiB.
+	MaxBodySize int64
+
+	// ShouldVisit, if set, is called with each link in scope before it is
+	// queued, and the link is skipped if it returns false.
+	ShouldVisit func(link string, depth int) bool
+	// OnPage is called with each page that is fetched. If it returns
+	// SkipLinks, the links on the page are not followed. If it returns any
+	// other error, the crawl stops and Crawl returns the error.
+	OnPage func(page *CrawledPage) error
+	// OnError is called with each URL that could not be fetched or returned a
+	// non-2XX response. Those URLs are skipped if it is not set.
+	OnError func(url string, err error)
+}
+
+type crawlTask struct {
+	url      string
+	referrer string
+	depth    int
+}
+
+type crawl struct {
+	crawler *Crawler
+	hosts   []string
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []crawlTask
+	seen   map[string]bool
+	queued int
+	active int
+	err    error
+
+	callbackMu sync.Mutex
+}
+
+// Crawl crawls from startURLs until every page in scope has been fetched. It
+// returns an error if a start URL is invalid or OnPage stops the crawl.
+func (c *Crawler) Crawl(startURLs ...string) error {
+	cr := &crawl{crawler: c, hosts: c.Scope.Hosts, seen: map[string]bool{}}
+	cr.cond = sync.NewCond(&cr.mu)
+
+	for _, startURL := range startURLs {
+		normalizedURL, err := normalizeCrawlURL(nil, startURL)
+		if err != nil {
+			return fmt.Errorf("invalid start URL %q: %w", startURL, err)
+		}
+
+		if len(c.Scope.Hosts) == 0 {
+			u, _ := url.Parse(normalizedURL)
+			cr.hosts = append(cr.hosts, u.Hostname())
+		}
+
+		cr.enqueue(crawlTask{url: normalizedURL})
+	}
+
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cr.work()
+		}()
+	}
+	wg.Wait()
+
+	return cr.err
+}
+
+// enqueue queues the task unless its URL has been seen or the page limit has
+// been reached. It must be called with mu held, except before the workers
+// start.
+func (cr *crawl) enqueue(task crawlTask) {
+	if cr.seen[task.url] {
+		return
+	}
+	if maxPages := cr.crawler.Scope.MaxPages; maxPages > 0 && cr.queued >= maxPages {
+		return
+	}
+
+	cr.seen[task.url] = true
+	cr.queued++
+	cr.queue = append(cr.queue, task)
+}
+
+func (cr *crawl) work() {
+	for {
+		cr.mu.Lock()
+		for len(cr.queue) == 0 && cr.active > 0 && cr.err == nil {
+			cr.cond.Wait()
+		}
+		if len(cr.queue) == 0 || cr.err != nil {
+			cr.cond.Broadcast()
+			cr.mu.Unlock()
+			return
+		}
+
+		task := cr.queue[0]
+		cr.queue = cr.queue[1:]
+		cr.active++
+		cr.mu.Unlock()
+
+		links, err := cr.visit(task)
+
+		cr.mu.Lock()
+		cr.active--
+		if err != nil && cr.err == nil {
+			cr.err = err
+		}
+		for _, link := range links {
+			cr.enqueue(crawlTask{url: link, referrer: task.url, depth: task.depth + 1})
+		}
+		cr.cond.Broadcast()
+		cr.mu.Unlock()
+	}
+}
+
+// visit fetches the page for task and returns the links on it to follow.
+// Errors fetching the page are reported to OnError rather than returned, as
+// they do not stop the crawl.
+func (cr *crawl) visit(task crawlTask) ([]string, error) {
+	page, err := cr.fetch(task)
+	if err != nil {
+		if cr.crawler.OnError != nil {
+			cr.callbackMu.Lock()
+			cr.crawler.OnError(task.url, err)
+			cr.callbackMu.Unlock()
+		}
+		return nil, nil
+	}
+	if page == nil {
+		return nil, nil
+	}
+
+	if cr.crawler.OnPage != nil {
+		cr.callbackMu.Lock()
+		err := cr.crawler.OnPage(page)
+		cr.callbackMu.Unlock()
+
+		if errors.Is(err, SkipLinks) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if maxDepth := cr.crawler.Scope.MaxDepth; maxDepth > 0 && task.depth >= maxDepth {
+		return nil, nil
+	}
+
+	var links []string
+	for _, link := range page.Links {
+		if !cr.inScope(link) {
+			continue
+		}
+		if cr.crawler.ShouldVisit != nil {
+			cr.callbackMu.Lock()
+			shouldVisit := cr.crawler.ShouldVisit(link, task.depth+1)
+			cr.callbackMu.Unlock()
+			if !shouldVisit {
+				continue
+			}
+		}
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// fetch fetches the page for task, or returns nil if it redirects to a page
+// which has already been crawled.
+func (cr *crawl) fetch(task crawlTask) (*CrawledPage, error) {
+	var options []RequestOption
+	if task.referrer != "" {
+		options = append(options, WithReferer(task.referrer))
+	}
+
+	resp, err := cr.crawler.Browser.Get(task.url, options...)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("got non-2XX response: %s", resp.Status)
+	}
+
+	maxBodySize := cr.crawler.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxCrawlBodySize
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	page := &CrawledPage{
+		URL:      task.url,
+		Referrer: task.referrer,
+		Depth:    task.depth,
+		Response: resp,
+		Body:     body,
+	}
+
+	// Pages which redirect to a URL that has already been seen are only
+	// crawled once.
+	if finalURL, err := normalizeCrawlURL(nil, resp.Request.URL.String()); err == nil && finalURL != task.url {
+		cr.mu.Lock()
+		seen := cr.seen[finalURL]
+		cr.seen[finalURL] = true
+		cr.mu.Unlock()
+
+		if seen {
+			return nil, nil
+		}
+	}
+
+	if isHTML(resp.Header.Get("Content-Type")) {
+		page.Links = extractLinks(resp.Request.URL, body)
+	}
+
+	return page, nil
+}
+
+func (cr *crawl) inScope(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+
+	hostname := u.Hostname()
+
+	var hostInScope bool
+	for _, host := range cr.hosts {
+		host = strings.ToLower(host)
+		if hostname == host || (cr.crawler.Scope.IncludeSubdomains && strings.HasSuffix(hostname, "."+host)) {
+			hostInScope = true
+			break
+		}
+	}
+	if !hostInScope {
+		return false
+	}
+
+	if len(cr.crawler.Scope.PathPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range cr.crawler.Scope.PathPrefixes {
+		if strings.HasPrefix(u.Path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isHTML(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// extractLinks returns the normalized URLs of the links, areas and frames in
+// an HTML document, resolved against its <base> or else pageURL. Links marked
+// rel="nofollow" are skipped.
+func extractLinks(pageURL *url.URL, body []byte) []string {
+	base := pageURL
+
+	var links []string
+	tokenizer := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return links
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := tokenizer.Token()
+
+		var attr string
+		switch token.Data {
+		case "base":
+			if href := tokenAttr(token, "href"); href != "" {
+				if baseURL, err := pageURL.Parse(href); err == nil {
+					base = baseURL
+				}
+			}
+			continue
+		case "a", "area":
+			if hasRelNofollow(token) {
+				continue
+			}
+			attr = "href"
+		case "iframe", "frame":
+			attr = "src"
+		default:
+			continue
+		}
+
+		link, err := normalizeCrawlURL(base, tokenAttr(token, attr))
+		if err != nil {
+			continue
+		}
+		links = append(links, link)
+	}
+}
+
+func tokenAttr(token html.Token, name string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == name {
+			return strings.TrimSpace(attr.Val)
+		}
+	}
+	return ""
+}
+
+func hasRelNofollow(token html.Token) bool {
+	for _, rel := range strings.Fields(strings.ToLower(tokenAttr(token, "rel"))) {
+		if rel == "nofollow" {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeCrawlURL resolves rawURL against base, if it is set, and
+// normalizes it so that URLs for the same page compare equal: the scheme and
+// host are lowercased, default ports, fragments and dot segments are removed,
+// and percent-encoding is canonicalized. Only http and https URLs are
+// accepted.
+func normalizeCrawlURL(base *url.URL, rawURL string) (string, error) {
+	if rawURL == "" {
+		return "", errors.New("empty URL")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("expected an http or https URL, got %q", rawURL)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("missing host in %q", rawURL)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	// The path is normalized in its escaped form, as decoding reserved
+	// characters like "%2F" would change the resource it refers to.
+	escapedPath := normalizePercentEncoding(u.EscapedPath())
+	if escapedPath == "" {
+		escapedPath = "/"
+	}
+	if !strings.HasPrefix(escapedPath, "/") {
+		escapedPath = "/" + escapedPath
+	}
+	escapedPath = resolveDotSegments(escapedPath)
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid path in %q: %w", rawURL, err)
+	}
+	u.Path = path
+	u.RawPath = escapedPath
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// normalizePercentEncoding decodes percent-encoded unreserved characters and
+// uppercases the hex digits of the other escapes, as in RFC 3986 section 6.2.2.
+func normalizePercentEncoding(s string) string {
+	var normalized strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+3 > len(s) {
+			normalized.WriteByte(s[i])
+			continue
+		}
+
+		b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			normalized.WriteByte(s[i])
+			continue
+		}
+		if isUnreserved(byte(b)) {
+			normalized.WriteByte(byte(b))
+		} else {
+			normalized.WriteString("%" + strings.ToUpper(s[i+1:i+3]))
+		}
+		i += 2
+	}
+	return normalized.String()
+}
+
+// isUnreserved reports whether c is an unreserved character in RFC 3986, which
+// means the same whether it is percent-encoded or not.
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// resolveDotSegments removes "." and ".." segments from an absolute path,
+// which ResolveReference only does for relative references.
+func resolveDotSegments(path string) string {
+	segments := strings.Split(path, "/")
+
+	var resolved []string
+	for i, segment := range segments[1:] {
+		last := i == len(segments)-2
+		switch segment {
+		case ".":
+			if last {
+				resolved = append(resolved, "")
+			}
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+			if last {
+				resolved = append(resolved, "")
+			}
+		default:
+			resolved = append(resolved, segment)
+		}
+	}
+
+	return "/" + strings.Join(resolved, "/")
+}
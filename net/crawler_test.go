@@ -0,0 +1,311 @@
+package net_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCrawler(t *testing.T) {
+	spec.Run(t, "Crawler", testCrawler, spec.Report(report.Terminal{}))
+}
+
+func testCrawler(t *testing.T, context spec.G, it spec.S) {
+	var (
+		server *httptest.Server
+		pages  map[string]string
+
+		mu                sync.Mutex
+		requests          []string
+		referrers         map[string]string
+		activeRequests    int
+		maxActiveRequests int
+		requestDelay      time.Duration
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		requests = nil
+		referrers = map[string]string{}
+		activeRequests = 0
+		maxActiveRequests = 0
+		requestDelay = 0
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests = append(requests, r.URL.RequestURI())
+			referrers[r.URL.RequestURI()] = r.Header.Get("Referer")
+			activeRequests++
+			if activeRequests > maxActiveRequests {
+				maxActiveRequests = activeRequests
+			}
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				activeRequests--
+				mu.Unlock()
+			}()
+
+			time.Sleep(requestDelay)
+
+			switch r.URL.Path {
+			case "/redirect":
+				http.Redirect(w, r, "/a", http.StatusFound)
+				return
+			case "/some-file.bin":
+				w.Header().Set("Content-Type", "application/octet-stream")
+				_, _ = w.Write([]byte(`<a href="/not-a-link">`))
+				return
+			}
+
+			page, ok := pages[r.URL.RequestURI()]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte(page))
+		}))
+
+		pages = map[string]string{
+			"/": `<html><body>
+<a href="/a">A</a>
+<a href="/b#some-fragment">B</a>
+<a href="./a">A again</a>
+<a href="` + strings.ToUpper(strings.TrimPrefix(server.URL, "http://")) + `">Not a URL</a>
+<a href="HTTP://` + strings.TrimPrefix(server.URL, "http://") + `/c/../c?x=1#top">C</a>
+<a href="/private/some-page">Private</a>
+<a href="https://other.example.com/">Other host</a>
+<a href="mailto:someone@example.com">Mail</a>
+<a href="/nofollow" rel="external nofollow">Nofollow</a>
+<a href="/redirect">Redirect</a>
+<a href="/missing">Missing</a>
+<a href="/some-file.bin">File</a>
+</body></html>`,
+			"/a":                  `<a href="deep">Deep</a><iframe src="/frame"></iframe>`,
+			"/b":                  `<base href="/base/"><a href="relative">Relative</a>`,
+			"/c?x=1":              `<a href="/">Home</a>`,
+			"/deep":               `<a href="/deeper">Deeper</a>`,
+			"/deeper":             `No links`,
+			"/frame":              `No links`,
+			"/nofollow":           `No links`,
+			"/base/relative":      `No links`,
+			"/private/some-page":  `<a href="/private/other-page">Other</a>`,
+			"/private/other-page": `No links`,
+		}
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	crawledURLs := func(pages []*net.CrawledPage) []string {
+		var urls []string
+		for _, page := range pages {
+			urls = append(urls, strings.TrimPrefix(page.URL, server.URL))
+		}
+		sort.Strings(urls)
+		return urls
+	}
+
+	it("crawls each page in scope once", func() {
+		var (
+			crawledPages []*net.CrawledPage
+			failedURLs   []string
+		)
+		crawler := net.Crawler{
+			Browser: net.NewBrowser(),
+			OnPage: func(page *net.CrawledPage) error {
+				crawledPages = append(crawledPages, page)
+				return nil
+			},
+			OnError: func(url string, err error) {
+				failedURLs = append(failedURLs, strings.TrimPrefix(url, server.URL))
+				assert.EqualError(err, "got non-2XX response: 404 Not Found")
+			},
+		}
+
+		require.NoError(crawler.Crawl(server.URL))
+
+		assert.Equal([]string{
+			"/",
+			"/a",
+			"/b",
+			"/base/relative",
+			"/c?x=1",
+			"/deep",
+			"/deeper",
+			"/frame",
+			"/private/other-page",
+			"/private/some-page",
+			"/some-file.bin",
+		}, crawledURLs(crawledPages))
+		assert.Equal([]string{"/missing"}, failedURLs)
+
+		for _, page := range crawledPages {
+			switch page.URL {
+			case server.URL + "/":
+				assert.Equal(0, page.Depth)
+				assert.Empty(page.Referrer)
+				assert.Contains(page.Links, "https://other.example.com/")
+				assert.Contains(page.Links, server.URL+"/b")
+				assert.NotContains(page.Links, server.URL+"/nofollow")
+				assert.Equal(http.StatusOK, page.Response.StatusCode)
+			case server.URL + "/deep":
+				assert.Equal(2, page.Depth)
+				assert.Equal(server.URL+"/a", page.Referrer)
+			case server.URL + "/some-file.bin":
+				assert.Empty(page.Links)
+				assert.Equal(`<a href="/not-a-link">`, string(page.Body))
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(server.URL+"/a", referrers["/deep"])
+		assert.NotContains(requests, "/nofollow")
+		assert.NotContains(requests, "/not-a-link")
+	})
+
+	it("keeps reserved characters in paths escaped", func() {
+		pages["/"] = `<a href="/files/a%2Fb">Escaped slash</a><a href="/files/a%2fb">Same</a><a href="/%7Euser">Tilde</a>`
+		pages["/files/a%2Fb"] = `No links`
+		pages["/~user"] = `No links`
+
+		var crawledPages []*net.CrawledPage
+		crawler := net.Crawler{
+			Browser: net.NewBrowser(),
+			OnPage: func(page *net.CrawledPage) error {
+				crawledPages = append(crawledPages, page)
+				return nil
+			},
+		}
+
+		require.NoError(crawler.Crawl(server.URL))
+
+		assert.Equal([]string{"/", "/files/a%2Fb", "/~user"}, crawledURLs(crawledPages))
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.NotContains(requests, "/files/a/b")
+	})
+
+	it("limits the crawl to the scope", func() {
+		var crawledPages []*net.CrawledPage
+		crawler := net.Crawler{
+			Browser: net.NewBrowser(),
+			Scope: net.CrawlScope{
+				PathPrefixes: []string{"/private/", "/a"},
+				MaxDepth:     1,
+			},
+			OnPage: func(page *net.CrawledPage) error {
+				crawledPages = append(crawledPages, page)
+				return nil
+			},
+		}
+
+		require.NoError(crawler.Crawl(server.URL + "/"))
+		assert.Equal([]string{"/", "/a", "/private/some-page"}, crawledURLs(crawledPages))
+
+		crawledPages = nil
+		crawler.Scope = net.CrawlScope{MaxPages: 3, Hosts: []string{"other.example.com"}}
+		require.NoError(crawler.Crawl(server.URL))
+		assert.Equal([]string{"/"}, crawledURLs(crawledPages))
+
+		crawledPages = nil
+		crawler.Scope = net.CrawlScope{MaxPages: 3}
+		require.NoError(crawler.Crawl(server.URL))
+		assert.Len(crawledPages, 3)
+	})
+
+	it("filters links with ShouldVisit", func() {
+		var crawledPages []*net.CrawledPage
+		crawler := net.Crawler{
+			Browser: net.NewBrowser(),
+			ShouldVisit: func(link string, depth int) bool {
+				return depth == 1 && !strings.HasSuffix(link, "/b")
+			},
+			OnPage: func(page *net.CrawledPage) error {
+				crawledPages = append(crawledPages, page)
+				return nil
+			},
+		}
+
+		require.NoError(crawler.Crawl(server.URL))
+		assert.Equal([]string{"/", "/a", "/c?x=1", "/private/some-page", "/some-file.bin"}, crawledURLs(crawledPages))
+	})
+
+	it("skips the links on a page when OnPage returns SkipLinks", func() {
+		var crawledPages []*net.CrawledPage
+		crawler := net.Crawler{
+			Browser: net.NewBrowser(),
+			OnPage: func(page *net.CrawledPage) error {
+				crawledPages = append(crawledPages, page)
+				if page.URL == server.URL+"/a" {
+					return fmt.Errorf("some-wrapper: %w", net.SkipLinks)
+				}
+				return nil
+			},
+		}
+
+		require.NoError(crawler.Crawl(server.URL))
+		assert.NotContains(crawledURLs(crawledPages), "/deep")
+		assert.NotContains(crawledURLs(crawledPages), "/frame")
+	})
+
+	it("stops when OnPage returns an error", func() {
+		someErr := errors.New("some-error")
+		crawler := net.Crawler{
+			Browser:     net.NewBrowser(),
+			Concurrency: 2,
+			OnPage: func(page *net.CrawledPage) error {
+				if page.URL == server.URL+"/a" {
+					return someErr
+				}
+				return nil
+			},
+		}
+
+		assert.ErrorIs(crawler.Crawl(server.URL), someErr)
+	})
+
+	it("fetches up to Concurrency pages at once", func() {
+		requestDelay = 20 * time.Millisecond
+
+		var crawledPages int
+		crawler := net.Crawler{
+			Browser:     net.NewBrowser(),
+			Concurrency: 3,
+			OnPage: func(page *net.CrawledPage) error {
+				crawledPages++
+				return nil
+			},
+		}
+
+		require.NoError(crawler.Crawl(server.URL))
+		assert.Equal(11, crawledPages)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(3, maxActiveRequests)
+	})
+
+	it("returns an error for invalid start URLs", func() {
+		crawler := net.Crawler{Browser: net.NewBrowser()}
+		assert.EqualError(crawler.Crawl("ftp://example.com"), `invalid start URL "ftp://example.com": expected an http or https URL, got "ftp://example.com"`)
+	})
+}
@@ -1,74 +1,159 @@
 package net
 
 import (
+	"context"
 	"fmt"
-	"github.com/mdelillo/go-utils/ansi"
 	"io"
 	"math"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
-func DownloadFilesWithProgress(fileDownloads []FileDownload) error {
-	const printInterval = time.Second / 10
+type DownloadOption func(*downloadOptions)
+
+type downloadOptions struct {
+	ctx              context.Context
+	downloader       *FileDownloader
+	writer           io.Writer
+	mode             ProgressMode
+	progressInterval time.Duration
+}
+
+// WithFileDownloader downloads the files with downloader, in place of one
+// with a Browser that times out after an hour.
+func WithFileDownloader(downloader *FileDownloader) func(*downloadOptions) {
+	return func(opts *downloadOptions) {
+		opts.downloader = downloader
+	}
+}
+
+// WithDownloadContext stops the downloads when ctx is cancelled. The
+// progress of every download is written one last time, showing which ones
+// were cancelled.
+func WithDownloadContext(ctx context.Context) func(*downloadOptions) {
+	return func(opts *downloadOptions) {
+		opts.ctx = ctx
+	}
+}
+
+// WithProgressWriter writes progress to writer in place of os.Stdout.
+func WithProgressWriter(writer io.Writer) func(*downloadOptions) {
+	return func(opts *downloadOptions) {
+		opts.writer = writer
+	}
+}
 
-	downloader := FileDownloader{
-		Browser: NewBrowser(WithClient(NewHTTPClient(WithTimeout(time.Hour)))),
+// WithProgressMode sets how progress is written. It defaults to
+// ProgressModeAuto.
+func WithProgressMode(mode ProgressMode) func(*downloadOptions) {
+	return func(opts *downloadOptions) {
+		opts.mode = mode
 	}
+}
 
-	progresses := &downloadsProgresses{
-		fileDownloads: fileDownloads,
-		writer: &ansi.InPlaceWriter{
-			Writer:    os.Stdout,
-			LineCount: len(fileDownloads),
-		},
+// WithProgressInterval sets how often the progress of a download is written
+// in the plain and JSON modes. Changes in the state of downloads are written
+// as they happen.
+func WithProgressInterval(interval time.Duration) func(*downloadOptions) {
+	return func(opts *downloadOptions) {
+		opts.progressInterval = interval
 	}
+}
+
+func DownloadFilesWithProgress(fileDownloads []FileDownload, options ...DownloadOption) error {
+	return downloadFilesWithProgress(fileDownloads, false, options).Err()
+}
+
+// DownloadFilesWithProgressResults is like DownloadFilesWithProgress, but it
+// keeps going when a download fails and returns the result of every download.
+func DownloadFilesWithProgressResults(fileDownloads []FileDownload, options ...DownloadOption) DownloadResults {
+	return downloadFilesWithProgress(fileDownloads, true, options)
+}
 
-	var previousPrint time.Time
-	err := downloader.DownloadFilesWithProgressUpdates(fileDownloads, func(downloadProgress DownloadProgress) {
-		progresses.update(downloadProgress)
+func downloadFilesWithProgress(fileDownloads []FileDownload, continueOnError bool, options []DownloadOption) DownloadResults {
+	opts := &downloadOptions{ctx: context.Background(), writer: os.Stdout, mode: ProgressModeAuto}
+	for _, option := range options {
+		option(opts)
+	}
 
-		now := time.Now()
-		if now.Sub(previousPrint) < printInterval {
-			return
+	downloader := opts.downloader
+	if downloader == nil {
+		downloader = &FileDownloader{
+			Browser: NewBrowser(WithClient(NewHTTPClient(WithTimeout(time.Hour)))),
 		}
+	}
+
+	output := newProgressOutput(fileDownloads, opts)
 
-		progresses.print()
+	// mu serializes progress updates from concurrent downloads.
+	var mu sync.Mutex
+	results := downloader.downloadFiles(opts.ctx, fileDownloads, func(downloadProgress DownloadProgress) {
+		mu.Lock()
+		defer mu.Unlock()
 
-		previousPrint = now
-	})
-	if err != nil {
-		return err
+		output.update(downloadProgress)
+	}, continueOnError)
+	if !continueOnError && results.Err() != nil && opts.ctx.Err() == nil {
+		return results
 	}
 
-	progresses.print()
+	output.flush()
 
-	return nil
+	return results
 }
 
+// downloadsProgresses draws a progress bar for each download, redrawing them
+// in place.
 type downloadsProgresses struct {
 	fileDownloads []FileDownload
 	writer        io.Writer
-	progresses    map[FileDownload]DownloadProgress
+	progresses    map[int]DownloadProgress
+	// frame animates the progress bars of downloads of unknown size.
+	frame         int
+	previousPrint time.Time
 }
 
 func (d *downloadsProgresses) update(downloadProgress DownloadProgress) {
+	const printInterval = time.Second / 10
+
 	if d.progresses == nil {
-		d.progresses = map[FileDownload]DownloadProgress{}
+		d.progresses = map[int]DownloadProgress{}
+	}
+
+	d.progresses[downloadProgress.Index] = downloadProgress
+
+	now := time.Now()
+	if now.Sub(d.previousPrint) < printInterval {
+		return
 	}
 
-	d.progresses[downloadProgress.FileDownload] = downloadProgress
+	d.print()
+
+	d.previousPrint = now
+}
+
+func (d *downloadsProgresses) flush() {
+	d.print()
 }
 
 func (d *downloadsProgresses) print() {
 	var output string
 
-	for _, fileDownload := range d.fileDownloads {
-		progress := d.progresses[fileDownload]
+	for i, fileDownload := range d.fileDownloads {
+		progress, ok := d.progresses[i]
+		if ok {
+			// Files downloaded to a directory are only named once they have
+			// been probed.
+			fileDownload = progress.FileDownload
+		}
 
-		if progress.TotalBytes > 0 && progress.DownloadedBytes == progress.TotalBytes {
+		switch progress.State {
+		case DownloadStateSkipped:
+			output += fmt.Sprintf("%s is up to date (%s)\n", fileDownload.location(), formatFileSize(float64(progress.TotalBytes)))
+			continue
+		case DownloadStateDone:
 			var precision time.Duration
 			if progress.DownloadTime < time.Second {
 				precision = time.Millisecond
@@ -77,16 +162,49 @@ func (d *downloadsProgresses) print() {
 			}
 
 			output += fmt.Sprintf("Downloaded %s (%s in %s)\n",
-				fileDownload.FilePath,
-				d.formatFileSize(float64(progress.TotalBytes)),
+				fileDownload.location(),
+				formatFileSize(float64(progress.DownloadedBytes)),
 				progress.DownloadTime.Round(precision),
 			)
+			continue
+		case DownloadStateFailed:
+			output += fmt.Sprintf("Failed to download %s: %s\n", fileDownload.name(), progress.Err)
+			continue
+		case DownloadStateCancelled:
+			output += fmt.Sprintf("Cancelled %s (%s downloaded)\n", fileDownload.name(), formatFileSize(float64(progress.DownloadedBytes)))
+			continue
+		case DownloadStateProbing, DownloadStateVerifying, DownloadStateExtracting:
+			output += fmt.Sprintf("%s (%s)\n", fileDownload.name(), progress.State)
+			continue
+		case DownloadStateQueued:
+			if progress.TotalBytes < 0 {
+				output += fmt.Sprintf("%s (%s)\n", fileDownload.name(), progress.State)
+				continue
+			}
+		case DownloadStateConnecting, DownloadStateRetrying:
+			if progress.DownloadedBytes == 0 {
+				output += fmt.Sprintf("%s (%s, attempt %d)\n", fileDownload.name(), progress.State, progress.Attempts)
+				continue
+			}
+		}
+
+		if progress.TotalBytes < 0 {
+			if progress.DownloadedBytes == 0 {
+				output += fmt.Sprintf("%s (unknown size)\n", fileDownload.name())
+				continue
+			}
 
+			output += fmt.Sprintf("Downloading %s: %s  %s (%s/s)\n",
+				fileDownload.name(),
+				indeterminateProgressBar(d.frame),
+				formatFileSize(float64(progress.DownloadedBytes)),
+				formatFileSize(progress.BytesPerSecond),
+			)
 			continue
 		}
 
 		if progress.DownloadedBytes == 0 {
-			output += fmt.Sprintf("%s (%s)\n", filepath.Base(fileDownload.FilePath), d.formatFileSize(float64(progress.TotalBytes)))
+			output += fmt.Sprintf("%s (%s)\n", fileDownload.name(), formatFileSize(float64(progress.TotalBytes)))
 			continue
 		}
 
@@ -100,21 +218,43 @@ func (d *downloadsProgresses) print() {
 			strings.Repeat(" ", 50-percentComplete/2),
 		)
 
-		remainingDownloadTime := (time.Duration(float64(progress.TotalBytes-progress.DownloadedBytes)/progress.AverageBytesPerMicrosecond) + 999*time.Microsecond) * time.Microsecond
-
-		output += fmt.Sprintf("Downloading %s: %s  %s/%s (%s remaining)\n",
-			filepath.Base(fileDownload.FilePath),
+		output += fmt.Sprintf("Downloading %s: %s  %s/%s (%s/s, %s remaining)\n",
+			fileDownload.name(),
 			progressBar,
-			d.formatFileSize(float64(progress.DownloadedBytes)),
-			d.formatFileSize(float64(progress.TotalBytes)),
-			remainingDownloadTime.Truncate(time.Second),
+			formatFileSize(float64(progress.DownloadedBytes)),
+			formatFileSize(float64(progress.TotalBytes)),
+			formatFileSize(progress.BytesPerSecond),
+			progress.ETA,
 		)
 	}
 
+	d.frame++
+
 	_, _ = fmt.Fprint(d.writer, output)
 }
 
-func (d *downloadsProgresses) formatFileSize(bytes float64) string {
+// indeterminateProgressBar returns a progress bar with a block that moves back
+// and forth as frame increases.
+func indeterminateProgressBar(frame int) string {
+	const (
+		width      = 50
+		blockWidth = 5
+		positions  = width - blockWidth
+	)
+
+	position := frame % (2 * positions)
+	if position > positions {
+		position = 2*positions - position
+	}
+
+	return fmt.Sprintf("[%s%s%s]",
+		strings.Repeat(" ", position),
+		strings.Repeat("#", blockWidth),
+		strings.Repeat(" ", positions-position),
+	)
+}
+
+func formatFileSize(bytes float64) string {
 	oneKB := math.Pow(2, 10)
 	oneMB := math.Pow(2, 20)
 	oneGB := math.Pow(2, 30)
@@ -0,0 +1,168 @@
+package net_test
+
+import (
+	"bufio"
+	"bytes"
+	gocontext "context"
+	"encoding/json"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadFilesWithProgress(t *testing.T) {
+	spec.Run(t, "DownloadFilesWithProgress", testDownloadFilesWithProgress, spec.Report(report.Terminal{}))
+}
+
+func testDownloadFilesWithProgress(t *testing.T, context spec.G, it spec.S) {
+	var (
+		server        *httptest.Server
+		handler       *fileServerHandler
+		fileDownloads []net.FileDownload
+		downloader    *net.FileDownloader
+		output        *bytes.Buffer
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		handler = &fileServerHandler{
+			content:     bytes.Repeat([]byte("0123456789"), 1000),
+			statusCodes: map[string]int{"/missing-file": http.StatusNotFound},
+		}
+		server = httptest.NewServer(handler)
+
+		tempDir := t.TempDir()
+		fileDownloads = []net.FileDownload{
+			{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-file")},
+			{URL: server.URL + "/missing-file", FilePath: filepath.Join(tempDir, "missing-file")},
+		}
+		downloader = &net.FileDownloader{Browser: net.NewBrowser()}
+		output = &bytes.Buffer{}
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("writes plain log lines to writers that are not terminals", func() {
+		results := net.DownloadFilesWithProgressResults(fileDownloads,
+			net.WithFileDownloader(downloader),
+			net.WithProgressWriter(output),
+		)
+		require.Len(results, 2)
+
+		assert.NotContains(output.String(), "\033")
+		assert.Contains(output.String(), "Downloading some-file from "+server.URL+"/some-file\n")
+		assert.Contains(output.String(), "Downloaded "+fileDownloads[0].FilePath+" (9.77KB in ")
+		assert.Contains(output.String(), "Failed to download missing-file: failed to get content size of missing-file: got non-2XX response: 404 Not Found\n")
+	})
+
+	it("names downloads to a Sink by their URL", func() {
+		var contents bytes.Buffer
+		sinkDownloads := []net.FileDownload{{
+			URL:  server.URL + "/some-file",
+			Sink: net.NewWriterSink(func() (io.Writer, error) { return &contents, nil }),
+		}}
+
+		for _, mode := range []net.ProgressMode{net.ProgressModePlain, net.ProgressModeInteractive} {
+			output.Reset()
+			results := net.DownloadFilesWithProgressResults(sinkDownloads,
+				net.WithFileDownloader(downloader),
+				net.WithProgressWriter(output),
+				net.WithProgressMode(mode),
+			)
+			require.NoError(results.Err())
+
+			assert.Contains(output.String(), "Downloaded "+server.URL+"/some-file (9.77KB in ", mode)
+		}
+	})
+
+	it("writes the final state of downloads when the context is cancelled", func() {
+		ctx, cancel := gocontext.WithCancel(gocontext.Background())
+		cancel()
+
+		results := net.DownloadFilesWithProgressResults(fileDownloads,
+			net.WithDownloadContext(ctx),
+			net.WithFileDownloader(downloader),
+			net.WithProgressWriter(output),
+			net.WithProgressMode(net.ProgressModeInteractive),
+		)
+		require.Len(results.Cancelled(), 2)
+
+		assert.Contains(output.String(), "Cancelled some-file (0B downloaded)\nCancelled missing-file (0B downloaded)\n")
+	})
+
+	it("writes progress bars in the interactive mode", func() {
+		net.DownloadFilesWithProgressResults(fileDownloads,
+			net.WithFileDownloader(downloader),
+			net.WithProgressWriter(output),
+			net.WithProgressMode(net.ProgressModeInteractive),
+		)
+
+		assert.Contains(output.String(), "\033[u")
+		assert.Contains(output.String(), "Downloaded "+fileDownloads[0].FilePath)
+	})
+
+	it("writes a JSON event on each line in the JSON mode", func() {
+		net.DownloadFilesWithProgressResults(fileDownloads,
+			net.WithFileDownloader(downloader),
+			net.WithProgressWriter(output),
+			net.WithProgressMode(net.ProgressModeJSON),
+		)
+
+		var events []net.DownloadProgressEvent
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			var event net.DownloadProgressEvent
+			require.NoError(json.Unmarshal(scanner.Bytes(), &event), scanner.Text())
+			events = append(events, event)
+		}
+		require.NotEmpty(events)
+
+		states := map[int][]net.DownloadState{}
+		for _, event := range events {
+			states[event.Index] = append(states[event.Index], event.State)
+			assert.False(event.Time.IsZero())
+		}
+		assert.Equal([]net.DownloadState{
+			net.DownloadStateQueued,
+			net.DownloadStateProbing,
+			net.DownloadStateQueued,
+			net.DownloadStateConnecting,
+			net.DownloadStateDownloading,
+			net.DownloadStateDone,
+		}, states[0])
+		assert.Equal([]net.DownloadState{
+			net.DownloadStateQueued,
+			net.DownloadStateProbing,
+			net.DownloadStateFailed,
+		}, states[1])
+
+		var lastEvent net.DownloadProgressEvent
+		for _, event := range events {
+			if event.Index == 0 {
+				lastEvent = event
+			}
+		}
+		assert.Equal(server.URL+"/some-file", lastEvent.URL)
+		assert.Equal(fileDownloads[0].FilePath, lastEvent.FilePath)
+		assert.Equal(int64(10000), lastEvent.DownloadedBytes)
+		assert.Equal(1, lastEvent.Attempts)
+
+		for _, event := range events {
+			if event.State == net.DownloadStateFailed {
+				assert.Equal(server.URL+"/missing-file", event.URL)
+				assert.Contains(event.Error, "404 Not Found")
+			}
+		}
+	})
+}
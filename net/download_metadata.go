@@ -0,0 +1,83 @@
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdelillo/go-utils/files"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// FileMetadata records the validators of a downloaded file so that it can be
+// conditionally requested on the next download.
+type FileMetadata struct {
+	URL          string
+	ETag         string
+	LastModified string
+	Size         int64
+}
+
+type MetadataStore interface {
+	Get(filePath string) (metadata FileMetadata, found bool, err error)
+	Put(filePath string, metadata FileMetadata) error
+}
+
+var _ MetadataStore = (*JSONMetadataStore)(nil)
+
+// JSONMetadataStore is a MetadataStore persisted to a single JSON file, which
+// is rewritten atomically on every Put.
+type JSONMetadataStore struct {
+	path string
+
+	mu       sync.Mutex
+	metadata map[string]FileMetadata
+}
+
+func NewJSONMetadataStore(path string) (*JSONMetadataStore, error) {
+	store := &JSONMetadataStore{
+		path:     path,
+		metadata: map[string]FileMetadata{},
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	if err := json.Unmarshal(contents, &store.metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	return store, nil
+}
+
+func (s *JSONMetadataStore) Get(filePath string) (FileMetadata, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metadata, found := s.metadata[filePath]
+	return metadata, found, nil
+}
+
+func (s *JSONMetadataStore) Put(filePath string, metadata FileMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.metadata[filePath] = metadata
+
+	contents, err := json.MarshalIndent(s.metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize metadata: %w", err)
+	}
+
+	if err := files.WriteFile(s.path, contents, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,300 @@
+package net
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DownloadState is the stage of its lifecycle a download is in.
+type DownloadState string
+
+const (
+	DownloadStateQueued      DownloadState = "queued"
+	DownloadStateProbing     DownloadState = "probing"
+	DownloadStateConnecting  DownloadState = "connecting"
+	DownloadStateDownloading DownloadState = "downloading"
+	DownloadStateRetrying    DownloadState = "retrying"
+	DownloadStateVerifying   DownloadState = "verifying"
+	DownloadStateExtracting  DownloadState = "extracting"
+	DownloadStateDone        DownloadState = "done"
+	DownloadStateFailed      DownloadState = "failed"
+	DownloadStateSkipped     DownloadState = "skipped"
+	DownloadStateCancelled   DownloadState = "cancelled"
+)
+
+type DownloadProgress struct {
+	FileDownload FileDownload
+	// Index is the position of FileDownload in the downloaded slice.
+	Index int
+	// URL is the mirror the file is being downloaded from.
+	URL   string
+	State DownloadState
+	// TotalBytes is -1 until the download is done if the server does not
+	// send the size of the file.
+	TotalBytes                 int64
+	DownloadedBytes            int64
+	AverageBytesPerMicrosecond float64
+	DownloadTime               time.Duration
+	// BytesPerSecond is the current download speed, smoothed so that it
+	// follows changes in speed over a few seconds.
+	BytesPerSecond float64
+	// ETA is the estimated time left at the current speed, or zero if it
+	// cannot be estimated.
+	ETA time.Duration
+	// Attempts is the number of requests made to download the file so far,
+	// including retries.
+	Attempts int
+	// Err is why the download failed, in the failed and cancelled states.
+	Err error
+	// UpToDate is set in the skipped state, when the file has not changed
+	// since it was last downloaded.
+	UpToDate bool
+	// Done is set in the done state, on the last update for a file once it
+	// has been downloaded and verified.
+	Done bool
+}
+
+type DownloadProgressCallback func(DownloadProgress)
+
+// speedTimeConstant is how quickly the smoothed download speed follows the
+// actual speed. After a change in speed, the smoothed speed moves about 63% of
+// the way to the new speed within this time.
+const speedTimeConstant = 2 * time.Second
+
+// speedSampleInterval is the minimum time between samples of the download
+// speed, so that bursts of small writes do not skew it.
+const speedSampleInterval = 100 * time.Millisecond
+
+// downloadProgressTracker accumulates the bytes written for a single file and
+// reports them to the callback. It is safe for concurrent use so that the
+// segments of a file are summed into a single stream of progress updates.
+type downloadProgressTracker struct {
+	callback DownloadProgressCallback
+	index    int
+
+	mu                sync.Mutex
+	fileDownload      FileDownload
+	url               string
+	state             DownloadState
+	err               error
+	contentLength     int64
+	attempts          int
+	totalWrittenBytes int64
+	firstWrite        time.Time
+	bytesPerSecond    float64
+	sampleTime        time.Time
+	sampleBytes       int64
+}
+
+func (t *downloadProgressTracker) setState(state DownloadState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state = state
+	t.callback(t.progress())
+}
+
+func (t *downloadProgressTracker) setFileDownload(fileDownload FileDownload) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.fileDownload = fileDownload
+}
+
+func (t *downloadProgressTracker) setURL(url string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.url = url
+}
+
+func (t *downloadProgressTracker) setContentLength(contentLength int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.contentLength = contentLength
+}
+
+func (t *downloadProgressTracker) totalBytes() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.contentLength
+}
+
+func (t *downloadProgressTracker) writtenBytes() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.totalWrittenBytes
+}
+
+func (t *downloadProgressTracker) attemptCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.attempts
+}
+
+// attempt counts a request made to download the file. Requests retried by the
+// Browser are reported as retrying.
+func (t *downloadProgressTracker) attempt(attempt int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.attempts++
+
+	switch {
+	case attempt > 1:
+		t.state = DownloadStateRetrying
+	case t.state == DownloadStateDownloading:
+		// Other segments of the file are still downloading.
+		return
+	default:
+		t.state = DownloadStateConnecting
+	}
+
+	t.callback(t.progress())
+}
+
+// retry reports that part of the file failed to download and will be
+// downloaded again.
+func (t *downloadProgressTracker) retry() {
+	t.setState(DownloadStateRetrying)
+}
+
+func (t *downloadProgressTracker) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.firstWrite.IsZero() {
+		t.firstWrite = time.Now()
+		t.sampleTime = t.firstWrite
+	}
+}
+
+// reset forgets the bytes written so far, for a file that is downloaded again
+// from the start.
+func (t *downloadProgressTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totalWrittenBytes = 0
+	t.sampleBytes = 0
+}
+
+func (t *downloadProgressTracker) add(writtenBytes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totalWrittenBytes += int64(writtenBytes)
+	t.state = DownloadStateDownloading
+	t.sampleSpeed(time.Now())
+
+	t.callback(t.progress())
+}
+
+// sampleSpeed folds the speed since the last sample into an exponentially
+// weighted moving average, weighting samples by how long they cover.
+func (t *downloadProgressTracker) sampleSpeed(now time.Time) {
+	elapsed := now.Sub(t.sampleTime)
+	if elapsed < speedSampleInterval {
+		return
+	}
+
+	speed := float64(t.totalWrittenBytes-t.sampleBytes) / elapsed.Seconds()
+	if speed < 0 {
+		// The file was reset.
+		speed = 0
+	}
+
+	if t.bytesPerSecond == 0 {
+		t.bytesPerSecond = speed
+	} else {
+		weight := 1 - math.Exp(-elapsed.Seconds()/speedTimeConstant.Seconds())
+		t.bytesPerSecond += weight * (speed - t.bytesPerSecond)
+	}
+
+	t.sampleTime = now
+	t.sampleBytes = t.totalWrittenBytes
+}
+
+// finish reports that the file has been downloaded, at which point its size
+// is known even if the server did not send it.
+func (t *downloadProgressTracker) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state = DownloadStateDone
+	t.contentLength = t.totalWrittenBytes
+
+	t.callback(t.progress())
+}
+
+func (t *downloadProgressTracker) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state = DownloadStateFailed
+	t.err = err
+
+	t.callback(t.progress())
+}
+
+func (t *downloadProgressTracker) cancel(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state = DownloadStateCancelled
+	t.err = err
+
+	t.callback(t.progress())
+}
+
+// skip reports that the file is up to date, along with the size of the
+// existing file.
+func (t *downloadProgressTracker) skip(size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.state = DownloadStateSkipped
+	t.contentLength = size
+	t.totalWrittenBytes = size
+
+	t.callback(t.progress())
+}
+
+func (t *downloadProgressTracker) progress() DownloadProgress {
+	progress := DownloadProgress{
+		FileDownload:    t.fileDownload,
+		Index:           t.index,
+		URL:             t.url,
+		State:           t.state,
+		TotalBytes:      t.contentLength,
+		DownloadedBytes: t.totalWrittenBytes,
+		Attempts:        t.attempts,
+		Err:             t.err,
+		UpToDate:        t.state == DownloadStateSkipped,
+		Done:            t.state == DownloadStateDone,
+	}
+
+	if !t.firstWrite.IsZero() && t.state != DownloadStateSkipped {
+		now := time.Now()
+		progress.AverageBytesPerMicrosecond = float64(t.totalWrittenBytes) / float64(now.Sub(t.firstWrite).Microseconds())
+		progress.DownloadTime = now.Sub(t.firstWrite)
+
+		progress.BytesPerSecond = t.bytesPerSecond
+		if progress.BytesPerSecond == 0 && progress.DownloadTime > 0 {
+			// There is no sample yet.
+			progress.BytesPerSecond = float64(t.totalWrittenBytes) / progress.DownloadTime.Seconds()
+		}
+	}
+
+	if progress.BytesPerSecond > 0 && progress.TotalBytes >= 0 && progress.State == DownloadStateDownloading {
+		remainingSeconds := float64(progress.TotalBytes-progress.DownloadedBytes) / progress.BytesPerSecond
+		progress.ETA = time.Duration(remainingSeconds * float64(time.Second)).Round(time.Second)
+	}
+
+	return progress
+}
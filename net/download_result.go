@@ -0,0 +1,130 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type DownloadStatus string
+
+const (
+	DownloadStatusSucceeded DownloadStatus = "succeeded"
+	DownloadStatusFailed    DownloadStatus = "failed"
+	DownloadStatusUpToDate  DownloadStatus = "up to date"
+	// DownloadStatusCancelled is the status of downloads which were stopped,
+	// or never started, because their context was cancelled.
+	DownloadStatusCancelled DownloadStatus = "cancelled"
+)
+
+type DownloadResult struct {
+	FileDownload FileDownload
+	// URL is the mirror the file was downloaded from, or the last one tried
+	// if the download failed.
+	URL      string
+	Status   DownloadStatus
+	Bytes    int64
+	Duration time.Duration
+	// Attempts is the number of requests made to download the file,
+	// including retries.
+	Attempts int
+	Err      error
+}
+
+type DownloadResults []DownloadResult
+
+func (r DownloadResults) Failed() DownloadResults {
+	var failed DownloadResults
+	for _, result := range r {
+		if result.Status == DownloadStatusFailed {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func (r DownloadResults) Cancelled() DownloadResults {
+	var cancelled DownloadResults
+	for _, result := range r {
+		if result.Status == DownloadStatusCancelled {
+			cancelled = append(cancelled, result)
+		}
+	}
+	return cancelled
+}
+
+// Err returns an error wrapping the errors of all failed and cancelled
+// downloads, or nil if none failed or were cancelled. The errors of cancelled
+// downloads wrap the error of their context, like context.Canceled.
+func (r DownloadResults) Err() error {
+	var errs []error
+	for _, result := range r {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &downloadErrors{errs: errs}
+}
+
+// WriteSummary writes a table with one row per download.
+func (r DownloadResults) WriteSummary(writer io.Writer) error {
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(tw, "FILE\tSTATUS\tSIZE\tTIME\tATTEMPTS\tERROR")
+	for _, result := range r {
+		var errMessage string
+		if result.Err != nil {
+			errMessage = result.Err.Error()
+		}
+
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			result.FileDownload.name(),
+			result.Status,
+			formatFileSize(float64(result.Bytes)),
+			result.Duration.Round(10*time.Millisecond),
+			result.Attempts,
+			errMessage,
+		)
+	}
+
+	return tw.Flush()
+}
+
+// downloadErrors joins the errors of several downloads, like errors.Join.
+type downloadErrors struct {
+	errs []error
+}
+
+func (e *downloadErrors) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e *downloadErrors) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *downloadErrors) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mdelillo/go-utils/files"
+	"io"
+)
+
+// Sink receives the contents of a download in place of the file at FilePath.
+// Writers which implement io.Closer are closed once the download finishes,
+// whether or not it succeeded.
+//
+// A download which has to start over, because it failed over to a mirror which
+// does not accept byte ranges or its checksum did not match, closes its writer
+// and asks the sink for another one. The sink must return a fresh writer for
+// each attempt, not one which has already been written to, or the file would
+// be written twice.
+type Sink struct {
+	newWriter   func() (io.Writer, error)
+	newWriterAt func(size int64) (io.WriterAt, error)
+}
+
+// NewWriterSink returns a Sink which writes downloads sequentially to the
+// writer returned by newWriter, which is called again whenever the download
+// starts over. Downloads to it are never segmented.
+func NewWriterSink(newWriter func() (io.Writer, error)) *Sink {
+	return &Sink{newWriter: newWriter}
+}
+
+// NewWriterAtSink returns a Sink which writes downloads to the writer returned
+// by newWriterAt, which is passed the content length of the file, or -1 if it
+// is unknown. It is called again whenever the download starts over. Segmented
+// downloads are only used for files with checksums when the writer also
+// implements io.ReaderAt, so that the file can be verified.
+func NewWriterAtSink(newWriterAt func(size int64) (io.WriterAt, error)) *Sink {
+	return &Sink{newWriterAt: newWriterAt}
+}
+
+// downloadOutput is an opened sink.
+type downloadOutput struct {
+	writer io.Writer
+	// writerAt is nil when the sink must be written sequentially.
+	writerAt io.WriterAt
+	// readerAt is nil when the sink cannot be read back.
+	readerAt io.ReaderAt
+	// file is only set for the default sink, which writes to FilePath.
+	file   *files.AtomicFile
+	closer io.Closer
+	done   bool
+}
+
+func openFileOutput(filePath string) (*downloadOutput, error) {
+	if err := files.RemoveStaleTempFiles(filePath, staleTempFileAge); err != nil {
+		return nil, fmt.Errorf("failed to remove stale temp files: %w", err)
+	}
+
+	file, err := files.CreateAtomic(filePath, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+
+	return &downloadOutput{
+		writer:   file,
+		writerAt: file,
+		readerAt: file,
+		file:     file,
+	}, nil
+}
+
+func (s *Sink) open(size int64) (*downloadOutput, error) {
+	output := &downloadOutput{}
+
+	if s.newWriterAt != nil {
+		writerAt, err := s.newWriterAt(size)
+		if err != nil {
+			return nil, err
+		}
+		if writerAt == nil {
+			return nil, errors.New("sink returned a nil writer")
+		}
+
+		output.writerAt = writerAt
+		output.writer = &offsetWriter{writer: writerAt}
+		output.readerAt, _ = writerAt.(io.ReaderAt)
+		output.closer, _ = writerAt.(io.Closer)
+		return output, nil
+	}
+
+	writer, err := s.newWriter()
+	if err != nil {
+		return nil, err
+	}
+	if writer == nil {
+		return nil, errors.New("sink returned a nil writer")
+	}
+
+	output.writer = writer
+	output.closer, _ = writer.(io.Closer)
+	return output, nil
+}
+
+// segmentable reports whether the output can be written out of order, and
+// read back to verify checksums if there are any.
+func (o *downloadOutput) segmentable(verify bool) bool {
+	return o.writerAt != nil && (!verify || o.readerAt != nil)
+}
+
+func (o *downloadOutput) commit() error {
+	if o.file != nil {
+		return o.file.Commit()
+	}
+
+	o.done = true
+	if o.closer != nil {
+		return o.closer.Close()
+	}
+	return nil
+}
+
+// abort discards a file, or closes any other sink. It does nothing if the
+// output has already been committed, so it is safe to defer.
+func (o *downloadOutput) abort() {
+	if o.file != nil {
+		_ = o.file.Abort()
+		return
+	}
+
+	if o.done {
+		return
+	}
+	o.done = true
+	if o.closer != nil {
+		_ = o.closer.Close()
+	}
+}
+
+// offsetWriter writes sequentially to an io.WriterAt.
+type offsetWriter struct {
+	writer io.WriterAt
+	offset int64
+}
+
+func (w *offsetWriter) Write(bytes []byte) (int, error) {
+	written, err := w.writer.WriteAt(bytes, w.offset)
+	w.offset += int64(written)
+	return written, err
+}
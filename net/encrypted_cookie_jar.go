@@ -0,0 +1,361 @@
+package net
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encrypted jars start with a header of:
+//   - the magic string "CJAR"
+//   - the version of the format, which is 1
+//   - how the key was made: 0 for a key which was given, or 1 for a key which
+//     was derived from a passphrase, followed by the number of PBKDF2
+//     iterations as a big-endian uint32 and the salt
+//   - the AES-GCM nonce
+//
+// The rest is the JSON of the jar's entries, encrypted with AES-GCM using the
+// header as additional data, so that changes to either are detected.
+const (
+	encryptedJarMagic   = "CJAR"
+	encryptedJarVersion = 1
+
+	encryptedJarKeyGiven      = 0
+	encryptedJarKeyPassphrase = 1
+
+	// passphraseIterations is the number of PBKDF2-HMAC-SHA256 iterations
+	// recommended by OWASP.
+	passphraseIterations = 600000
+	// maxPassphraseIterations stops tampered files from making loads take
+	// much longer than usual before the tampering is detected.
+	maxPassphraseIterations = 2 * passphraseIterations
+	passphraseSaltSize      = 16
+
+	cookieJarKeySize = 32
+)
+
+// ErrCookiesTampered is returned when encrypted cookies cannot be decrypted,
+// because the key is wrong or they were changed after they were encrypted.
+var ErrCookiesTampered = errors.New("failed to decrypt cookies: the key is wrong or they were tampered with")
+
+// CookieJarKey is the key cookie jars are encrypted with, which is either a
+// 256-bit AES key or a passphrase it is derived from.
+type CookieJarKey struct {
+	key        []byte
+	passphrase string
+
+	// mu locks the key derived from the passphrase, which is reused for the
+	// salt it was derived with because deriving it is deliberately slow.
+	mu            sync.Mutex
+	derivedKey    []byte
+	derivedSalt   []byte
+	derivedRounds uint32
+}
+
+// NewCookieJarKey returns a key made of the 32 bytes of key.
+func NewCookieJarKey(key []byte) (*CookieJarKey, error) {
+	if len(key) != cookieJarKeySize {
+		return nil, fmt.Errorf("cookie jar keys must be %d bytes, got %d", cookieJarKeySize, len(key))
+	}
+	return &CookieJarKey{key: append([]byte(nil), key...)}, nil
+}
+
+// CookieJarKeyFromEnv returns the key in the environment variable name, encoded
+// in base64 as by GenerateCookieJarKey.
+func CookieJarKeyFromEnv(name string) (*CookieJarKey, error) {
+	encoded, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("cookie jar key variable %s is not set", name)
+	}
+
+	key, err := decodeCookieJarKey(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cookie jar key in %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// CookieJarKeyFromFile returns the key in the file at path, encoded in base64
+// as by GenerateCookieJarKey.
+func CookieJarKeyFromFile(path string) (*CookieJarKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookie jar key: %w", err)
+	}
+
+	key, err := decodeCookieJarKey(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cookie jar key in %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// CookieJarPassphrase returns a key derived from passphrase with
+// PBKDF2-HMAC-SHA256 and a random salt, which is saved with the cookies.
+func CookieJarPassphrase(passphrase string) *CookieJarKey {
+	return &CookieJarKey{passphrase: passphrase}
+}
+
+// GenerateCookieJarKey returns a random key encoded in base64, to be stored in
+// an environment variable or a file.
+func GenerateCookieJarKey() (string, error) {
+	key := make([]byte, cookieJarKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate cookie jar key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func decodeCookieJarKey(encoded string) (*CookieJarKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("expected base64: %w", err)
+	}
+	return NewCookieJarKey(key)
+}
+
+// ExportEncrypted writes the entries in the jar encrypted with key.
+func (j *PersistableCookieJar) ExportEncrypted(writer io.Writer, key *CookieJarKey) error {
+	j.mu.Lock()
+	entries := copyJarEntries(j.entries)
+	j.mu.Unlock()
+
+	encrypted, err := encryptJarEntries(entries, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(encrypted)
+	return err
+}
+
+// ImportEncrypted replaces the entries in the jar with ones written by
+// ExportEncrypted. It returns ErrCookiesTampered if they cannot be decrypted
+// with key.
+func (j *PersistableCookieJar) ImportEncrypted(reader io.Reader, key *CookieJarKey) error {
+	encrypted, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read cookies: %w", err)
+	}
+
+	entries, err := decryptJarEntries(encrypted, key)
+	if err != nil {
+		return err
+	}
+
+	var evictions []cookieEviction
+	defer func() { j.reportEvictions(evictions) }()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries = entries
+	evictions = j.evictImported(time.Now())
+	j.nextSeqNum = seqNumAfter(entries, 0)
+	j.changed()
+
+	return nil
+}
+
+func encryptJarEntries(entries map[string]map[string]JarEntry, key *CookieJarKey) ([]byte, error) {
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte(encryptedJarMagic)
+	header = append(header, encryptedJarVersion)
+
+	aesKey := key.key
+	if aesKey == nil {
+		var salt []byte
+		var rounds uint32
+		aesKey, salt, rounds, err = key.deriveForEncryption()
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, encryptedJarKeyPassphrase)
+		header = append(header, make([]byte, 4)...)
+		binary.BigEndian.PutUint32(header[len(header)-4:], rounds)
+		header = append(header, salt...)
+	} else {
+		header = append(header, encryptedJarKeyGiven)
+	}
+
+	aead, err := newCookieJarCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header = append(header, nonce...)
+
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+func decryptJarEntries(encrypted []byte, key *CookieJarKey) (map[string]map[string]JarEntry, error) {
+	reader := bytes.NewReader(encrypted)
+
+	magic := make([]byte, len(encryptedJarMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != encryptedJarMagic {
+		return nil, errors.New("not an encrypted cookie jar")
+	}
+
+	version, err := reader.ReadByte()
+	if err != nil {
+		return nil, errors.New("encrypted cookie jar is truncated")
+	}
+	if version != encryptedJarVersion {
+		return nil, fmt.Errorf("unsupported encrypted cookie jar version %d", version)
+	}
+
+	keyType, err := reader.ReadByte()
+	if err != nil {
+		return nil, errors.New("encrypted cookie jar is truncated")
+	}
+
+	var aesKey []byte
+	switch keyType {
+	case encryptedJarKeyGiven:
+		if key.key == nil {
+			return nil, errors.New("cookie jar was encrypted with a key, not a passphrase")
+		}
+		aesKey = key.key
+	case encryptedJarKeyPassphrase:
+		if key.key != nil {
+			return nil, errors.New("cookie jar was encrypted with a passphrase, not a key")
+		}
+
+		var rounds uint32
+		if err := binary.Read(reader, binary.BigEndian, &rounds); err != nil {
+			return nil, errors.New("encrypted cookie jar is truncated")
+		}
+		if rounds == 0 || rounds > maxPassphraseIterations {
+			return nil, fmt.Errorf("invalid number of passphrase iterations %d", rounds)
+		}
+
+		salt := make([]byte, passphraseSaltSize)
+		if _, err := io.ReadFull(reader, salt); err != nil {
+			return nil, errors.New("encrypted cookie jar is truncated")
+		}
+
+		aesKey = key.derive(salt, rounds)
+	default:
+		return nil, fmt.Errorf("unsupported cookie jar key type %d", keyType)
+	}
+
+	aead, err := newCookieJarCipher(aesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(reader, nonce); err != nil {
+		return nil, errors.New("encrypted cookie jar is truncated")
+	}
+
+	headerSize := len(encrypted) - reader.Len()
+	header := encrypted[:headerSize]
+	plaintext, err := aead.Open(nil, nonce, encrypted[headerSize:], header)
+	if err != nil {
+		return nil, ErrCookiesTampered
+	}
+
+	entries := map[string]map[string]JarEntry{}
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse decrypted cookies: %w", err)
+	}
+
+	return entries, nil
+}
+
+func newCookieJarCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveForEncryption returns the key derived from the passphrase, along with
+// its salt and iterations. It reuses the last derived key, so that saving a jar
+// often does not derive one every time.
+func (k *CookieJarKey) deriveForEncryption() ([]byte, []byte, uint32, error) {
+	k.mu.Lock()
+	derived, salt, rounds := k.derivedKey, k.derivedSalt, k.derivedRounds
+	k.mu.Unlock()
+	if derived != nil {
+		return derived, salt, rounds, nil
+	}
+
+	salt = make([]byte, passphraseSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return k.derive(salt, passphraseIterations), salt, passphraseIterations, nil
+}
+
+// derive returns the key derived from the passphrase with salt and rounds, and
+// remembers it.
+func (k *CookieJarKey) derive(salt []byte, rounds uint32) []byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.derivedKey != nil && k.derivedRounds == rounds && bytes.Equal(k.derivedSalt, salt) {
+		return k.derivedKey
+	}
+
+	k.derivedKey = pbkdf2SHA256([]byte(k.passphrase), salt, int(rounds), cookieJarKeySize)
+	k.derivedSalt = append([]byte(nil), salt...)
+	k.derivedRounds = rounds
+	return k.derivedKey
+}
+
+// pbkdf2SHA256 derives a key of keySize bytes from password, as defined by
+// RFC 8018 section 5.2 with HMAC-SHA256 as the pseudorandom function.
+func pbkdf2SHA256(password, salt []byte, iterations, keySize int) []byte {
+	prf := hmac.New(sha256.New, password)
+	blocks := (keySize + prf.Size() - 1) / prf.Size()
+
+	var key []byte
+	index := make([]byte, 4)
+	u := make([]byte, 0, prf.Size())
+	t := make([]byte, prf.Size())
+	for block := 1; block <= blocks; block++ {
+		binary.BigEndian.PutUint32(index, uint32(block))
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(index)
+		u = prf.Sum(u[:0])
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for x := range t {
+				t[x] ^= u[x]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:keySize]
+}
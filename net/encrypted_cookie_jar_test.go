@@ -0,0 +1,214 @@
+package net_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedCookieJar(t *testing.T) {
+	spec.Run(t, "EncryptedCookieJar", testEncryptedCookieJar, spec.Report(report.Terminal{}))
+}
+
+func testEncryptedCookieJar(t *testing.T, context spec.G, it spec.S) {
+	var (
+		jar *net.PersistableCookieJar
+		u   *url.URL
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		var err error
+		u, err = url.Parse("https://example.com/")
+		require.NoError(err)
+
+		jar = net.NewPersistableCookieJar(nil)
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-secret-value", MaxAge: 3600}})
+	})
+
+	newKey := func() *net.CookieJarKey {
+		encoded, err := net.GenerateCookieJarKey()
+		require.NoError(err)
+		t.Setenv("COOKIE_JAR_KEY", encoded)
+
+		key, err := net.CookieJarKeyFromEnv("COOKIE_JAR_KEY")
+		require.NoError(err)
+		return key
+	}
+
+	export := func(key *net.CookieJarKey) []byte {
+		var encrypted bytes.Buffer
+		require.NoError(jar.ExportEncrypted(&encrypted, key))
+		return encrypted.Bytes()
+	}
+
+	context("ExportEncrypted and ImportEncrypted", func() {
+		it("round trip the jar with a key", func() {
+			key := newKey()
+			encrypted := export(key)
+			assert.NotContains(string(encrypted), "some-secret-value")
+
+			newJar := net.NewPersistableCookieJar(nil)
+			require.NoError(newJar.ImportEncrypted(bytes.NewReader(encrypted), key))
+			assert.Equal(jar.Cookies(u), newJar.Cookies(u))
+		})
+
+		it("round trip the jar with a passphrase", func() {
+			encrypted := export(net.CookieJarPassphrase("some-passphrase"))
+
+			newJar := net.NewPersistableCookieJar(nil)
+			require.NoError(newJar.ImportEncrypted(bytes.NewReader(encrypted), net.CookieJarPassphrase("some-passphrase")))
+			assert.Equal(jar.Cookies(u), newJar.Cookies(u))
+
+			err := newJar.ImportEncrypted(bytes.NewReader(encrypted), net.CookieJarPassphrase("some-other-passphrase"))
+			assert.ErrorIs(err, net.ErrCookiesTampered)
+		})
+
+		it("detects changes to the cookies or the header", func() {
+			key := newKey()
+			encrypted := export(key)
+
+			for _, i := range []int{10, len(encrypted) - 20, len(encrypted) - 1} {
+				tampered := append([]byte(nil), encrypted...)
+				tampered[i] ^= 1
+
+				err := net.NewPersistableCookieJar(nil).ImportEncrypted(bytes.NewReader(tampered), key)
+				assert.ErrorIs(err, net.ErrCookiesTampered)
+			}
+		})
+
+		it("returns an error with the wrong key", func() {
+			encrypted := export(newKey())
+
+			err := net.NewPersistableCookieJar(nil).ImportEncrypted(bytes.NewReader(encrypted), newKey())
+			assert.ErrorIs(err, net.ErrCookiesTampered)
+
+			err = net.NewPersistableCookieJar(nil).ImportEncrypted(bytes.NewReader(encrypted), net.CookieJarPassphrase("some-passphrase"))
+			assert.EqualError(err, "cookie jar was encrypted with a key, not a passphrase")
+		})
+
+		it("rejects passphrase iterations far above the default", func() {
+			encrypted := export(net.CookieJarPassphrase("some-passphrase"))
+			binary.BigEndian.PutUint32(encrypted[6:10], 10000000)
+
+			err := net.NewPersistableCookieJar(nil).ImportEncrypted(bytes.NewReader(encrypted), net.CookieJarPassphrase("some-passphrase"))
+			assert.EqualError(err, "invalid number of passphrase iterations 10000000")
+		})
+
+		it("can export while cookies are set", func() {
+			key := newKey()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					jar.SetCookies(u, []*http.Cookie{{Name: fmt.Sprintf("cookie-%d", i), Value: "some-value"}})
+				}
+			}()
+			for i := 0; i < 10; i++ {
+				export(key)
+			}
+			<-done
+		})
+
+		it("returns an error for other formats and versions", func() {
+			key := newKey()
+
+			err := net.NewPersistableCookieJar(nil).ImportEncrypted(bytes.NewReader([]byte("{}")), key)
+			assert.EqualError(err, "not an encrypted cookie jar")
+
+			encrypted := export(key)
+			encrypted[4] = 2
+			err = net.NewPersistableCookieJar(nil).ImportEncrypted(bytes.NewReader(encrypted), key)
+			assert.EqualError(err, "unsupported encrypted cookie jar version 2")
+		})
+	})
+
+	context("CookieJarKeyFromFile", func() {
+		it("reads a base64 key", func() {
+			encoded, err := net.GenerateCookieJarKey()
+			require.NoError(err)
+			path := filepath.Join(t.TempDir(), "key")
+			require.NoError(os.WriteFile(path, []byte(encoded+"\n"), 0600))
+
+			key, err := net.CookieJarKeyFromFile(path)
+			require.NoError(err)
+
+			raw, err := base64.StdEncoding.DecodeString(encoded)
+			require.NoError(err)
+			sameKey, err := net.NewCookieJarKey(raw)
+			require.NoError(err)
+
+			newJar := net.NewPersistableCookieJar(nil)
+			require.NoError(newJar.ImportEncrypted(bytes.NewReader(export(key)), sameKey))
+			assert.Equal(jar.Cookies(u), newJar.Cookies(u))
+		})
+
+		it("returns an error if the key is the wrong size", func() {
+			path := filepath.Join(t.TempDir(), "key")
+			require.NoError(os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString([]byte("too-short"))), 0600))
+
+			_, err := net.CookieJarKeyFromFile(path)
+			require.Error(err)
+			assert.Contains(err.Error(), "cookie jar keys must be 32 bytes, got 9")
+		})
+	})
+
+	context("CookieJarKeyFromEnv", func() {
+		it("returns an error if the variable is not set", func() {
+			_, err := net.CookieJarKeyFromEnv("SOME_UNSET_COOKIE_JAR_KEY")
+			assert.EqualError(err, "cookie jar key variable SOME_UNSET_COOKIE_JAR_KEY is not set")
+		})
+	})
+
+	context("FileCookieJar WithEncryption", func() {
+		it("saves the cookies encrypted", func() {
+			key := newKey()
+			path := filepath.Join(t.TempDir(), "cookies")
+
+			fileJar, err := net.NewFileCookieJar(path, net.WithEncryption(key))
+			require.NoError(err)
+			fileJar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-secret-value", MaxAge: 3600}})
+			require.NoError(fileJar.Close())
+
+			contents, err := os.ReadFile(path)
+			require.NoError(err)
+			assert.NotContains(string(contents), "some-secret-value")
+
+			newJar, err := net.NewFileCookieJar(path, net.WithEncryption(key))
+			require.NoError(err)
+			assert.Equal([]*http.Cookie{{Name: "some-cookie", Value: "some-secret-value"}}, newJar.Cookies(u))
+
+			_, err = net.NewFileCookieJar(path, net.WithEncryption(newKey()))
+			assert.ErrorIs(err, net.ErrCookiesTampered)
+		})
+
+		it("saves cookies imported into the jar", func() {
+			key := newKey()
+			encrypted := export(key)
+			path := filepath.Join(t.TempDir(), "cookies")
+
+			fileJar, err := net.NewFileCookieJar(path, net.WithEncryption(key))
+			require.NoError(err)
+			require.NoError(fileJar.ImportEncrypted(bytes.NewReader(encrypted), key))
+			require.NoError(fileJar.Close())
+
+			newJar, err := net.NewFileCookieJar(path, net.WithEncryption(key))
+			require.NoError(err)
+			assert.Equal(jar.Cookies(u), newJar.Cookies(u))
+		})
+	})
+}
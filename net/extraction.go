@@ -0,0 +1,553 @@
+package net
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type ArchiveFormat string
+
+const (
+	ArchiveFormatTar   ArchiveFormat = "tar"
+	ArchiveFormatTarGz ArchiveFormat = "tar.gz"
+	ArchiveFormatZip   ArchiveFormat = "zip"
+	ArchiveFormatGzip  ArchiveFormat = "gz"
+)
+
+// Extraction unpacks a downloaded archive into Dir. Tar and gzip archives are
+// extracted while they are downloaded. Zip archives are extracted once the
+// download is complete, because their index is at the end of the file.
+//
+// Entries are extracted into a staging directory and only moved into Dir once
+// the download has been verified.
+type Extraction struct {
+	Dir string
+
+	// Format is detected from the file path or URL when it is empty.
+	Format ArchiveFormat
+
+	// StripComponents removes this many leading path elements from each entry.
+	// Entries with fewer path elements are skipped.
+	StripComponents int
+
+	// Include and Exclude are path.Match patterns matched against the
+	// stripped entry path. Patterns without a "/" are also matched against
+	// the entry's base name. Entries are extracted when they match any
+	// Include pattern (or Include is empty) and no Exclude pattern.
+	Include []string
+	Exclude []string
+}
+
+type ArchiveEntryError struct {
+	Name   string
+	Reason string
+}
+
+func (e *ArchiveEntryError) Error() string {
+	return fmt.Sprintf("invalid archive entry %q: %s", e.Name, e.Reason)
+}
+
+func detectArchiveFormat(names ...string) (ArchiveFormat, error) {
+	for _, name := range names {
+		name = strings.ToLower(name)
+		switch {
+		case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+			return ArchiveFormatTarGz, nil
+		case strings.HasSuffix(name, ".tar"):
+			return ArchiveFormatTar, nil
+		case strings.HasSuffix(name, ".zip"):
+			return ArchiveFormatZip, nil
+		case strings.HasSuffix(name, ".gz"):
+			return ArchiveFormatGzip, nil
+		}
+	}
+
+	return "", fmt.Errorf("unable to detect archive format of %s", strings.Join(names, ", "))
+}
+
+type extractor struct {
+	options    Extraction
+	format     ArchiveFormat
+	name       string
+	stagingDir string
+}
+
+func newExtractor(fileDownload FileDownload) (*extractor, error) {
+	options := *fileDownload.Extract
+
+	var urlPath string
+	if u, err := url.Parse(fileDownload.URL); err == nil {
+		urlPath = u.Path
+	}
+
+	format := options.Format
+	if format == "" {
+		var err error
+		format, err = detectArchiveFormat(fileDownload.FilePath, urlPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch format {
+	case ArchiveFormatTar, ArchiveFormatTarGz, ArchiveFormatZip, ArchiveFormatGzip:
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %q", format)
+	}
+
+	name := filepath.Base(fileDownload.FilePath)
+	if name == "." || name == string(filepath.Separator) {
+		name = path.Base(urlPath)
+	}
+
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+
+	stagingDir, err := os.MkdirTemp(options.Dir, ".extract-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	return &extractor{
+		options:    options,
+		format:     format,
+		name:       name,
+		stagingDir: stagingDir,
+	}, nil
+}
+
+// streams reports whether the archive can be extracted as it is downloaded.
+func (e *extractor) streams() bool {
+	return e.format != ArchiveFormatZip
+}
+
+// extractStream extracts a tar or gzip archive from reader. It drains reader
+// so that the writer on the other end of a pipe is never blocked.
+func (e *extractor) extractStream(reader io.Reader) error {
+	var err error
+	switch e.format {
+	case ArchiveFormatTar:
+		err = e.extractTar(reader)
+	case ArchiveFormatTarGz:
+		var gzipReader *gzip.Reader
+		gzipReader, err = gzip.NewReader(reader)
+		if err == nil {
+			err = e.extractTar(gzipReader)
+		}
+	case ArchiveFormatGzip:
+		var gzipReader *gzip.Reader
+		gzipReader, err = gzip.NewReader(reader)
+		if err == nil {
+			err = e.extractGzip(gzipReader)
+		}
+	default:
+		err = fmt.Errorf("%s archives cannot be streamed", e.format)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(io.Discard, reader)
+	return err
+}
+
+// extractFile extracts an archive which has been fully downloaded.
+func (e *extractor) extractFile(readerAt io.ReaderAt, size int64) error {
+	if e.streams() {
+		return e.extractStream(io.NewSectionReader(readerAt, 0, size))
+	}
+
+	return e.extractZip(readerAt, size)
+}
+
+func (e *extractor) extractTar(reader io.Reader) error {
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		target, skip, err := e.targetPath(header.Name)
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = e.mkdir(header.Name, target)
+		case tar.TypeReg, tar.TypeRegA:
+			err = e.writeFile(header.Name, target, tarReader, header.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = e.symlink(header.Name, header.Linkname, target)
+		case tar.TypeLink:
+			err = e.link(header.Name, header.Linkname, target)
+		default:
+			// Devices, FIFOs and other special files are not extracted.
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *extractor) extractZip(readerAt io.ReaderAt, size int64) error {
+	zipReader, err := zip.NewReader(readerAt, size)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+
+	for _, zipFile := range zipReader.File {
+		target, skip, err := e.targetPath(zipFile.Name)
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+
+		mode := zipFile.Mode()
+		switch {
+		case mode.IsDir():
+			err = e.mkdir(zipFile.Name, target)
+		case mode&fs.ModeSymlink != 0:
+			err = e.extractZipSymlink(zipFile, target)
+		case mode.IsRegular():
+			err = e.extractZipFile(zipFile, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *extractor) extractZipFile(zipFile *zip.File, target string) error {
+	reader, err := zipFile.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", zipFile.Name, err)
+	}
+	defer reader.Close()
+
+	return e.writeFile(zipFile.Name, target, reader, zipFile.Mode())
+}
+
+func (e *extractor) extractZipSymlink(zipFile *zip.File, target string) error {
+	reader, err := zipFile.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", zipFile.Name, err)
+	}
+	defer reader.Close()
+
+	linkname, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", zipFile.Name, err)
+	}
+
+	return e.symlink(zipFile.Name, string(linkname), target)
+}
+
+func (e *extractor) extractGzip(reader io.Reader) error {
+	name := strings.TrimSuffix(e.name, path.Ext(e.name))
+	if name == "" {
+		name = e.name
+	}
+
+	target, skip, err := e.targetPath(name)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return nil
+	}
+
+	return e.writeFile(name, target, reader, 0644)
+}
+
+// targetPath returns where an entry should be extracted to in the staging
+// directory. It rejects entries which would be written outside of it.
+func (e *extractor) targetPath(name string) (string, bool, error) {
+	cleanName, err := cleanEntryName(name)
+	if err != nil {
+		return "", false, err
+	}
+
+	components := strings.Split(cleanName, "/")
+	if len(components) <= e.options.StripComponents {
+		return "", true, nil
+	}
+	strippedName := strings.Join(components[e.options.StripComponents:], "/")
+
+	if !e.included(strippedName) {
+		return "", true, nil
+	}
+
+	return filepath.Join(e.stagingDir, filepath.FromSlash(strippedName)), false, nil
+}
+
+func cleanEntryName(name string) (string, error) {
+	slashName := strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(slashName) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &ArchiveEntryError{Name: name, Reason: "absolute path"}
+	}
+
+	for _, component := range strings.Split(slashName, "/") {
+		if component == ".." {
+			return "", &ArchiveEntryError{Name: name, Reason: "path traversal"}
+		}
+	}
+
+	cleanName := path.Clean(slashName)
+	if cleanName == "." {
+		return "", &ArchiveEntryError{Name: name, Reason: "empty path"}
+	}
+
+	return cleanName, nil
+}
+
+func (e *extractor) included(name string) bool {
+	if len(e.options.Include) > 0 && !matchesAnyPattern(name, e.options.Include) {
+		return false
+	}
+	return !matchesAnyPattern(name, e.options.Exclude)
+}
+
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(name)); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// prepare creates the parent directories of target and removes anything
+// already at target. It rejects targets whose parent directories include a
+// symlink created by an earlier entry, which could otherwise be used to write
+// outside of the staging directory.
+func (e *extractor) prepare(name, target string) error {
+	rel, err := filepath.Rel(e.stagingDir, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	dir := e.stagingDir
+	if rel != "." {
+		for _, component := range strings.Split(rel, string(filepath.Separator)) {
+			dir = filepath.Join(dir, component)
+
+			info, err := os.Lstat(dir)
+			if errors.Is(err, fs.ErrNotExist) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if info.Mode()&fs.ModeSymlink != 0 {
+				return &ArchiveEntryError{Name: name, Reason: "path traverses a symlink"}
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+		return os.Remove(target)
+	}
+
+	return nil
+}
+
+func (e *extractor) mkdir(name, target string) error {
+	if err := e.prepare(name, target); err != nil {
+		return err
+	}
+	return os.MkdirAll(target, 0755)
+}
+
+func (e *extractor) writeFile(name, target string, reader io.Reader, mode fs.FileMode) error {
+	if err := e.prepare(name, target); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+
+	return file.Close()
+}
+
+// symlink creates a symlink as long as it resolves to a path inside of the
+// staging directory.
+func (e *extractor) symlink(name, linkname, target string) error {
+	resolved, ok := e.resolveLink(filepath.Dir(target), linkname, 0)
+	if !ok || !isWithinDir(e.stagingDir, resolved) {
+		return &ArchiveEntryError{Name: name, Reason: fmt.Sprintf("symlink to %q escapes the extraction directory", linkname)}
+	}
+
+	if err := e.prepare(name, target); err != nil {
+		return err
+	}
+
+	return os.Symlink(linkname, target)
+}
+
+// maxLinkHops limits how many symlinks resolveLink follows, so that loops of
+// symlinks in an archive end.
+const maxLinkHops = 40
+
+// resolveLink returns the path that linkname resolves to from the directory
+// dir. Symlinks which have already been extracted are followed rather than
+// cleaned lexically, since "y/.." is not dir itself if y is a symlink to a
+// subdirectory or to dir. It reports false if the symlinks loop.
+func (e *extractor) resolveLink(dir, linkname string, hops int) (string, bool) {
+	if hops > maxLinkHops {
+		return "", false
+	}
+
+	current := dir
+	if filepath.IsAbs(linkname) {
+		volume := filepath.VolumeName(linkname)
+		current = volume + string(filepath.Separator)
+		linkname = linkname[len(volume):]
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(linkname), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		if isWithinDir(e.stagingDir, next) {
+			if info, err := os.Lstat(next); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+				dest, err := os.Readlink(next)
+				if err != nil {
+					return "", false
+				}
+				var ok bool
+				if next, ok = e.resolveLink(current, dest, hops+1); !ok {
+					return "", false
+				}
+			}
+		}
+		current = next
+	}
+
+	return current, true
+}
+
+// link creates a hard link to an entry that has already been extracted.
+func (e *extractor) link(name, linkname, target string) error {
+	linkTarget, skip, err := e.targetPath(linkname)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return nil
+	}
+
+	if err := e.prepare(linkname, linkTarget); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(linkTarget); err != nil || !info.Mode().IsRegular() {
+		return &ArchiveEntryError{Name: name, Reason: fmt.Sprintf("hard link to %q is not a regular file", linkname)}
+	}
+
+	if err := e.prepare(name, target); err != nil {
+		return err
+	}
+
+	if err := os.Link(linkTarget, target); err != nil {
+		return fmt.Errorf("failed to link %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func isWithinDir(dir, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// commit moves the extracted entries from the staging directory into the
+// extraction directory, replacing any existing files.
+func (e *extractor) commit() error {
+	defer e.abort()
+
+	return filepath.WalkDir(e.stagingDir, func(stagedPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(e.stagingDir, stagedPath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		target := filepath.Join(e.options.Dir, rel)
+
+		if entry.IsDir() {
+			// Replace anything that isn't a real directory, so that a
+			// symlink already in the extraction directory is not followed.
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			return os.MkdirAll(target, 0755)
+		}
+
+		if info, err := os.Lstat(target); err == nil && info.IsDir() {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		return os.Rename(stagedPath, target)
+	})
+}
+
+// abort removes the staging directory.
+func (e *extractor) abort() {
+	_ = os.RemoveAll(e.stagingDir)
+}
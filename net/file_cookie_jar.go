@@ -0,0 +1,373 @@
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mdelillo/go-utils/files"
+	"io/fs"
+	"net/http/cookiejar"
+	"os"
+	"sync"
+	"time"
+)
+
+type FileCookieJarOption func(*FileCookieJar)
+
+// defaultCookieSaveDelay is how long changes to a FileCookieJar are batched
+// before they are saved.
+const defaultCookieSaveDelay = time.Second
+
+// FileCookieJar is a PersistableCookieJar which is loaded from a JSON file of
+// its exported entries, and saved back to it shortly after its cookies change
+// and when it is closed. The file can be encrypted with WithEncryption.
+//
+// Saves merge the changes made through the jar into the file as it is on disk,
+// under a lock, so that several processes can share the file without undoing
+// each other's changes. The jar picks up changes made by other processes when
+// it saves. The lock is a file next to the jar's file with a ".lock" suffix.
+type FileCookieJar struct {
+	*PersistableCookieJar
+
+	path               string
+	saveDelay          time.Duration
+	dropExpiredCookies bool
+	dropSessionCookies bool
+	onSaveError        func(error)
+	cookieJarOptions   *cookiejar.Options
+	jarOptions         []JarOption
+	key                *CookieJarKey
+
+	// saveMu serializes saves.
+	saveMu sync.Mutex
+	// saved is what the entries were after they were last loaded or saved,
+	// to tell which ones have changed since.
+	saved map[string]map[string]JarEntry
+
+	// mu locks the remaining fields. It is locked after the mu of the
+	// PersistableCookieJar when both are held.
+	mu     sync.Mutex
+	dirty  bool
+	timer  *time.Timer
+	closed bool
+}
+
+// WithCookieJarOptions creates the jar with options, such as its public suffix
+// list.
+func WithCookieJarOptions(options *cookiejar.Options) func(*FileCookieJar) {
+	return func(j *FileCookieJar) {
+		j.cookieJarOptions = options
+	}
+}
+
+// WithJarOptions creates the jar with options, such as its cookie limits.
+func WithJarOptions(options ...JarOption) func(*FileCookieJar) {
+	return func(j *FileCookieJar) {
+		j.jarOptions = append(j.jarOptions, options...)
+	}
+}
+
+// WithSaveDelay sets how long changes are batched before they are saved. It
+// defaults to a second.
+func WithSaveDelay(delay time.Duration) func(*FileCookieJar) {
+	return func(j *FileCookieJar) {
+		j.saveDelay = delay
+	}
+}
+
+// WithDropExpiredCookies leaves cookies which have expired out of the file.
+func WithDropExpiredCookies() func(*FileCookieJar) {
+	return func(j *FileCookieJar) {
+		j.dropExpiredCookies = true
+	}
+}
+
+// WithDropSessionCookies leaves session cookies out of the file, like a browser
+// forgetting them when it is closed. They are still kept in memory.
+func WithDropSessionCookies() func(*FileCookieJar) {
+	return func(j *FileCookieJar) {
+		j.dropSessionCookies = true
+	}
+}
+
+// WithEncryption encrypts the file with key, as by ExportEncrypted, instead of
+// saving the cookies as plain JSON.
+func WithEncryption(key *CookieJarKey) func(*FileCookieJar) {
+	return func(j *FileCookieJar) {
+		j.key = key
+	}
+}
+
+// WithSaveErrorHandler calls onSaveError with the errors of saves made in the
+// background after cookies change. They are ignored otherwise.
+func WithSaveErrorHandler(onSaveError func(error)) func(*FileCookieJar) {
+	return func(j *FileCookieJar) {
+		j.onSaveError = onSaveError
+	}
+}
+
+// NewFileCookieJar returns a jar with the cookies saved at path, which does not
+// need to exist yet.
+func NewFileCookieJar(path string, options ...FileCookieJarOption) (*FileCookieJar, error) {
+	j := &FileCookieJar{
+		path:      path,
+		saveDelay: defaultCookieSaveDelay,
+	}
+
+	for _, option := range options {
+		option(j)
+	}
+
+	j.PersistableCookieJar = NewPersistableCookieJar(j.cookieJarOptions, j.jarOptions...)
+
+	lock, err := files.LockFile(j.lockPath())
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	entries, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+
+	// The entries are saved as they are on disk, so that cookies evicted by the
+	// import for being over the jar's limits are removed from the file.
+	j.saved = copyJarEntries(entries)
+	j.PersistableCookieJar.onChange = j.scheduleSave
+	j.PersistableCookieJar.Import(entries)
+
+	return j, nil
+}
+
+func (j *FileCookieJar) lockPath() string {
+	return j.path + ".lock"
+}
+
+// scheduleSave saves the jar once the save delay has passed since the first
+// change that has not been saved. It is called with the mu of the
+// PersistableCookieJar held.
+func (j *FileCookieJar) scheduleSave() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.dirty = true
+	if j.closed || j.timer != nil {
+		return
+	}
+
+	j.timer = time.AfterFunc(j.saveDelay, func() {
+		j.mu.Lock()
+		j.timer = nil
+		j.mu.Unlock()
+
+		if err := j.Save(); err != nil && j.onSaveError != nil {
+			j.onSaveError(err)
+		}
+	})
+}
+
+// Save writes the cookies to the file now, merged with any changes made to it
+// by other processes since it was loaded or last saved.
+func (j *FileCookieJar) Save() error {
+	j.saveMu.Lock()
+	defer j.saveMu.Unlock()
+
+	lock, err := files.LockFile(j.lockPath())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	onDisk, err := j.read()
+	if err != nil {
+		return err
+	}
+
+	jar := j.PersistableCookieJar
+	jar.mu.Lock()
+	merged := mergeJarEntries(onDisk, j.saved, jar.entries)
+	jar.entries = copyJarEntries(merged)
+	jar.nextSeqNum = seqNumAfter(merged, jar.nextSeqNum)
+	j.mu.Lock()
+	j.dirty = false
+	j.mu.Unlock()
+	jar.mu.Unlock()
+
+	written, err := j.write(merged, time.Now())
+	if err != nil {
+		j.mu.Lock()
+		j.dirty = true
+		j.mu.Unlock()
+		return err
+	}
+
+	// Cookies left out of the file are compared as though they are new, so
+	// that they are not taken to have been removed by another process.
+	j.saved = written
+
+	return nil
+}
+
+// Close saves any changes which have not been saved yet. Changes made after
+// the jar is closed are not saved unless Save is called.
+func (j *FileCookieJar) Close() error {
+	j.mu.Lock()
+	j.closed = true
+	if j.timer != nil {
+		j.timer.Stop()
+		j.timer = nil
+	}
+	dirty := j.dirty
+	j.mu.Unlock()
+
+	if !dirty {
+		return nil
+	}
+
+	return j.Save()
+}
+
+func (j *FileCookieJar) read() (map[string]map[string]JarEntry, error) {
+	contents, err := os.ReadFile(j.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]map[string]JarEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookies: %w", err)
+	}
+
+	if j.key != nil {
+		entries, err := decryptJarEntries(contents, j.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load cookies in %s: %w", j.path, err)
+		}
+		return entries, nil
+	}
+
+	entries := map[string]map[string]JarEntry{}
+	if err := json.Unmarshal(contents, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse cookies in %s: %w", j.path, err)
+	}
+
+	return entries, nil
+}
+
+// write saves the entries which are not dropped, and returns them.
+func (j *FileCookieJar) write(entries map[string]map[string]JarEntry, now time.Time) (map[string]map[string]JarEntry, error) {
+	kept := map[string]map[string]JarEntry{}
+	for key, submap := range entries {
+		for id, e := range submap {
+			if j.dropExpiredCookies && e.Persistent && !e.Expires.After(now) {
+				continue
+			}
+			if j.dropSessionCookies && !e.Persistent {
+				continue
+			}
+			if kept[key] == nil {
+				kept[key] = map[string]JarEntry{}
+			}
+			kept[key][id] = e
+		}
+	}
+
+	var contents []byte
+	var err error
+	if j.key != nil {
+		contents, err = encryptJarEntries(kept, j.key)
+	} else {
+		contents, err = json.Marshal(kept)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The cookies may include session tokens, so only the user can read them.
+	if err := files.WriteFile(j.path, contents, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save cookies: %w", err)
+	}
+
+	return kept, nil
+}
+
+// mergeJarEntries applies the changes between saved and current to onDisk:
+// entries which were added or changed since they were saved replace those on
+// disk, and entries which were removed are removed from disk. Other entries on
+// disk were added or changed by another process, so they are kept.
+func mergeJarEntries(onDisk, saved, current map[string]map[string]JarEntry) map[string]map[string]JarEntry {
+	merged := copyJarEntries(onDisk)
+
+	for key, submap := range saved {
+		for id := range submap {
+			if _, ok := current[key][id]; ok {
+				continue
+			}
+			delete(merged[key], id)
+			if len(merged[key]) == 0 {
+				delete(merged, key)
+			}
+		}
+	}
+
+	for key, submap := range current {
+		for id, e := range submap {
+			if old, ok := saved[key][id]; ok && sameJarEntry(old, e) {
+				// Sending a cookie only updates its last access time, which
+				// is kept unless another process changed the cookie.
+				if onDisk, ok := merged[key][id]; ok && equalJarEntries(onDisk, old) {
+					merged[key][id] = e
+				}
+				continue
+			}
+			if merged[key] == nil {
+				merged[key] = map[string]JarEntry{}
+			}
+			merged[key][id] = e
+		}
+	}
+
+	return merged
+}
+
+// equalJarEntries reports whether a and b are the same cookie, accessed at the
+// same time.
+func equalJarEntries(a, b JarEntry) bool {
+	return sameJarEntry(a, b) && a.LastAccess.Equal(b.LastAccess)
+}
+
+// sameJarEntry reports whether a and b are the same cookie other than when they
+// were last accessed. Times are compared with Equal, as entries read from the
+// file have lost their monotonic clock readings and may have another location.
+func sameJarEntry(a, b JarEntry) bool {
+	if !a.Expires.Equal(b.Expires) || !a.Creation.Equal(b.Creation) {
+		return false
+	}
+	a.Expires, a.Creation, a.LastAccess = b.Expires, b.Creation, b.LastAccess
+	return a == b
+}
+
+func copyJarEntries(entries map[string]map[string]JarEntry) map[string]map[string]JarEntry {
+	copied := make(map[string]map[string]JarEntry, len(entries))
+	for key, submap := range entries {
+		copiedSubmap := make(map[string]JarEntry, len(submap))
+		for id, e := range submap {
+			copiedSubmap[id] = e
+		}
+		copied[key] = copiedSubmap
+	}
+	return copied
+}
+
+// seqNumAfter returns a sequence number after any used by entries, and at least
+// minimum.
+func seqNumAfter(entries map[string]map[string]JarEntry, minimum uint64) uint64 {
+	next := minimum
+	for _, submap := range entries {
+		for _, e := range submap {
+			if e.SeqNum >= next {
+				next = e.SeqNum + 1
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,206 @@
+package net_test
+
+import (
+	"encoding/json"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileCookieJar(t *testing.T) {
+	spec.Run(t, "FileCookieJar", testFileCookieJar, spec.Report(report.Terminal{}))
+}
+
+func testFileCookieJar(t *testing.T, context spec.G, it spec.S) {
+	var (
+		path string
+		u    *url.URL
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		path = filepath.Join(t.TempDir(), "cookies.json")
+
+		var err error
+		u, err = url.Parse("https://example.com/")
+		require.NoError(err)
+	})
+
+	cookieNames := func(jar http.CookieJar) []string {
+		var names []string
+		for _, cookie := range jar.Cookies(u) {
+			names = append(names, cookie.Name)
+		}
+		return names
+	}
+
+	savedCookieNames := func() []string {
+		contents, err := os.ReadFile(path)
+		require.NoError(err)
+
+		var entries map[string]map[string]net.JarEntry
+		require.NoError(json.Unmarshal(contents, &entries))
+
+		var names []string
+		for _, submap := range entries {
+			for _, entry := range submap {
+				names = append(names, entry.Name)
+			}
+		}
+		return names
+	}
+
+	it("loads the cookies saved when another jar was closed", func() {
+		jar, err := net.NewFileCookieJar(path)
+		require.NoError(err)
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-value"}})
+		require.NoError(jar.Close())
+
+		fileInfo, err := os.Stat(path)
+		require.NoError(err)
+		if fileInfo.Mode().Perm() != 0600 && filepath.Separator == '/' {
+			t.Errorf("expected cookies to only be readable by the user, got %s", fileInfo.Mode())
+		}
+
+		newJar, err := net.NewFileCookieJar(path)
+		require.NoError(err)
+		assert.Equal([]*http.Cookie{{Name: "some-cookie", Value: "some-value"}}, newJar.Cookies(u))
+	})
+
+	it("saves changes after the save delay", func() {
+		jar, err := net.NewFileCookieJar(path, net.WithSaveDelay(10*time.Millisecond))
+		require.NoError(err)
+		defer jar.Close()
+
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-value"}})
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-other-cookie", Value: "some-other-value"}})
+
+		assert.Eventually(func() bool {
+			_, err := os.Stat(path)
+			return err == nil && len(savedCookieNames()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	it("does not create the file if nothing changed", func() {
+		jar, err := net.NewFileCookieJar(path)
+		require.NoError(err)
+		require.NoError(jar.Close())
+
+		assert.NoFileExists(path)
+	})
+
+	it("merges the changes of jars in other processes", func() {
+		jar, err := net.NewFileCookieJar(path, net.WithSaveDelay(time.Hour))
+		require.NoError(err)
+		otherJar, err := net.NewFileCookieJar(path, net.WithSaveDelay(time.Hour))
+		require.NoError(err)
+
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-value", MaxAge: 3600}})
+		require.NoError(jar.Save())
+
+		otherJar.SetCookies(u, []*http.Cookie{{Name: "some-other-cookie", Value: "some-other-value", MaxAge: 3600}})
+		require.NoError(otherJar.Save())
+
+		assert.ElementsMatch([]string{"some-cookie", "some-other-cookie"}, savedCookieNames())
+		assert.ElementsMatch([]string{"some-cookie", "some-other-cookie"}, cookieNames(otherJar))
+
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", MaxAge: -1}})
+		require.NoError(jar.Close())
+
+		assert.Equal([]string{"some-other-cookie"}, savedCookieNames())
+		assert.Equal([]string{"some-other-cookie"}, cookieNames(jar))
+	})
+
+	it("keeps the changes of other processes to cookies which were only sent", func() {
+		jar, err := net.NewFileCookieJar(path, net.WithSaveDelay(time.Hour))
+		require.NoError(err)
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-value", MaxAge: 3600}})
+		require.NoError(jar.Save())
+
+		otherJar, err := net.NewFileCookieJar(path, net.WithSaveDelay(time.Hour))
+		require.NoError(err)
+		otherJar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-new-value", MaxAge: 3600}})
+		require.NoError(otherJar.Save())
+
+		time.Sleep(time.Millisecond)
+		jar.Cookies(u)
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-other-cookie", Value: "some-other-value", MaxAge: 3600}})
+		require.NoError(jar.Save())
+
+		newJar, err := net.NewFileCookieJar(path)
+		require.NoError(err)
+		assert.ElementsMatch([]*http.Cookie{
+			{Name: "some-cookie", Value: "some-new-value"},
+			{Name: "some-other-cookie", Value: "some-other-value"},
+		}, newJar.Cookies(u))
+	})
+
+	it("saves when cookies were last sent", func() {
+		jar, err := net.NewFileCookieJar(path, net.WithSaveDelay(time.Hour))
+		require.NoError(err)
+		jar.SetCookies(u, []*http.Cookie{{Name: "some-cookie", Value: "some-value", MaxAge: 3600}})
+		require.NoError(jar.Save())
+
+		time.Sleep(10 * time.Millisecond)
+		jar.Cookies(u)
+		lastAccess := jar.Entries()[0].LastAccess
+		require.NoError(jar.Save())
+
+		assert.True(lastAccess.Equal(jar.Entries()[0].LastAccess), "expected %s, got %s", lastAccess, jar.Entries()[0].LastAccess)
+
+		newJar, err := net.NewFileCookieJar(path)
+		require.NoError(err)
+		assert.True(lastAccess.Equal(newJar.Entries()[0].LastAccess), "expected %s, got %s", lastAccess, newJar.Entries()[0].LastAccess)
+	})
+
+	it("returns an error if the file is not a saved jar", func() {
+		require.NoError(os.WriteFile(path, []byte("not-json"), 0600))
+
+		_, err := net.NewFileCookieJar(path)
+		require.Error(err)
+		assert.Contains(err.Error(), "failed to parse cookies in "+path)
+	})
+
+	context("WithDropSessionCookies", func() {
+		it("leaves session cookies out of the file but keeps them in memory", func() {
+			jar, err := net.NewFileCookieJar(path, net.WithDropSessionCookies(), net.WithSaveDelay(time.Hour))
+			require.NoError(err)
+
+			jar.SetCookies(u, []*http.Cookie{
+				{Name: "session-cookie", Value: "some-value"},
+				{Name: "persistent-cookie", Value: "some-other-value", MaxAge: 3600},
+			})
+			require.NoError(jar.Save())
+			assert.Equal([]string{"persistent-cookie"}, savedCookieNames())
+
+			require.NoError(jar.Save())
+			assert.ElementsMatch([]string{"session-cookie", "persistent-cookie"}, cookieNames(jar))
+		})
+	})
+
+	context("WithDropExpiredCookies", func() {
+		it("leaves expired cookies out of the file", func() {
+			jar, err := net.NewFileCookieJar(path, net.WithDropExpiredCookies(), net.WithSaveDelay(time.Hour))
+			require.NoError(err)
+
+			jar.SetCookies(u, []*http.Cookie{
+				{Name: "expiring-cookie", Value: "some-value", Expires: time.Now().Add(100 * time.Millisecond)},
+				{Name: "persistent-cookie", Value: "some-other-value", MaxAge: 3600},
+			})
+			time.Sleep(200 * time.Millisecond)
+			require.NoError(jar.Save())
+
+			assert.Equal([]string{"persistent-cookie"}, savedCookieNames())
+		})
+	})
+}
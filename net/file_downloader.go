@@ -1,143 +1,1293 @@
 package net
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/mdelillo/go-utils/files"
 	"io"
+	"io/fs"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// FileDownload is a file to download. It is comparable, so it can be used as a
+// map key, which is why its optional settings are behind pointers.
 type FileDownload struct {
 	URL                    string
 	FilePath               string
 	UseGetForContentLength bool
+
+	// Dir is the directory the file is saved to when FilePath is empty. The
+	// file is named after the Content-Disposition header of the response, the
+	// path of the URL it was redirected to, or the path of URL, in that order.
+	// A numeric suffix is added to names which are already taken, unless the
+	// downloader's ReplaceExisting is set.
+	Dir string
+
+	// Options are the mirrors, headers and checksums of the download.
+	Options *FileDownloadOptions
+
+	// Extract unpacks the downloaded archive into a directory, in addition to
+	// saving it to FilePath.
+	Extract *Extraction
+
+	// Sink receives the downloaded file in place of FilePath, which is then
+	// only used to name it. Downloads to a Sink are not recorded in the
+	// downloader's MetadataStore.
+	Sink *Sink
+}
+
+// FileDownloadOptions are the optional settings of a FileDownload.
+type FileDownloadOptions struct {
+	// Mirrors are URLs serving the same file as URL. They are tried in order
+	// when a request fails, returns a non-2XX response or the file fails
+	// checksum verification. Interrupted downloads are resumed from the last
+	// byte received when the mirror supports byte ranges.
+	Mirrors []string
+
+	// ShuffleMirrors tries URL and Mirrors in a random order, to spread
+	// downloads across them.
+	ShuffleMirrors bool
+
+	// Headers are added to every request made for the file.
+	Headers map[string]string
+
+	// Checksums are verified against the downloaded file. A mismatch fails
+	// the download and the file is removed, or moved to the downloader's
+	// QuarantineDir.
+	Checksums []Checksum
+
+	// FetchChecksumFile adds the SHA-256 digest found in the "<URL>.sha256"
+	// sidecar file to the expected checksums.
+	FetchChecksumFile bool
+
+	// UseDigestHeaders adds the digests advertised in the Digest and
+	// Content-Digest response headers to the expected checksums.
+	UseDigestHeaders bool
+}
+
+// options returns the options of the download, which are all unset if it has
+// none.
+func (f FileDownload) options() FileDownloadOptions {
+	if f.Options == nil {
+		return FileDownloadOptions{}
+	}
+	return *f.Options
 }
 
-type DownloadProgress struct {
-	FileDownload               FileDownload
-	TotalBytes                 int64
-	DownloadedBytes            int64
-	AverageBytesPerMicrosecond float64
-	DownloadTime               time.Duration
+// location returns where the file was downloaded to, or its URL if it was
+// downloaded to a Sink without a FilePath, for use in messages.
+func (f FileDownload) location() string {
+	if f.FilePath != "" {
+		return f.FilePath
+	}
+	return f.URL
 }
 
-type DownloadProgressCallback func(DownloadProgress)
+// name returns the name of the downloaded file, for use in messages.
+func (f FileDownload) name() string {
+	if f.FilePath != "" {
+		return filepath.Base(f.FilePath)
+	}
+	if u, err := url.Parse(f.URL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		return path.Base(u.Path)
+	}
+	return f.URL
+}
 
 type FileDownloader struct {
 	Browser *Browser
+
+	// Concurrency is the number of files downloaded at once. It defaults to
+	// 1. The progress callback is called from multiple goroutines when it is
+	// greater.
+	Concurrency int
+
+	// Segments is the number of byte ranges a file is split into and
+	// downloaded in parallel. Files are only segmented when the server
+	// advertises byte range support and the content length is known.
+	Segments int
+
+	// SegmentRetries is the number of times a failed segment is retried,
+	// starting from the last byte received, before the download fails.
+	SegmentRetries int
+
+	// QuarantineDir is where files that fail checksum verification are moved.
+	// They are deleted if it is empty.
+	QuarantineDir string
+
+	// MetadataStore records the ETag, Last-Modified and size of downloaded
+	// files. Files are requested conditionally based on their stored metadata
+	// and skipped when the server reports that they have not been modified.
+	MetadataStore MetadataStore
+
+	// Force downloads every file, ignoring the metadata store.
+	Force bool
+
+	// ReplaceExisting replaces files which already exist when naming
+	// downloads in their Dir, instead of adding a numeric suffix. Names are
+	// still suffixed when they are taken by another download.
+	ReplaceExisting bool
+
+	// KeepPartial keeps what was downloaded of a file when its download is
+	// cancelled, next to FilePath with a ".partial" suffix. The next download
+	// of the file resumes from it if the server supports byte ranges and the
+	// file has not changed. Otherwise, cancelled downloads are removed. Files
+	// downloaded in segments, extracted or written to a Sink are never kept.
+	KeepPartial bool
+}
+
+// partialFileSuffix names the file that a cancelled download is kept in, and
+// partialMetadataSuffix the file recording where it was downloaded from.
+const (
+	partialFileSuffix     = ".partial"
+	partialMetadataSuffix = ".partial.json"
+)
+
+// staleTempFileAge is how long a temp file must go unmodified before it is
+// assumed to have been left behind by a crashed download.
+const staleTempFileAge = time.Minute
+
+// errNotModified is returned when a conditional request for a file gets a 304
+// response.
+var errNotModified = errors.New("not modified")
+
+// errDownloadAborted stops the extraction of a download that did not finish.
+var errDownloadAborted = errors.New("download aborted")
+
+type contentInfo struct {
+	contentLength int64
+	acceptsRanges bool
+	checksums     []Checksum
+	etag          string
+	lastModified  string
+	fileName      string
+}
+
+// downloadJob is the state of a single file as it moves through the
+// downloader.
+type downloadJob struct {
+	ctx          context.Context
+	fileDownload FileDownload
+	// urls are the URL and mirrors of the file, in the order they are tried,
+	// and mirror is the index of the one currently in use.
+	urls       []string
+	mirror     int
+	info       contentInfo
+	conditions []RequestOption
+	tracker    *downloadProgressTracker
+	output     *downloadOutput
+	checksums  []Checksum
+	verifier   *checksumVerifier
+	extractor  *extractor
+	stream     *downloadStream
+	segments   []*segment
 }
 
 func (d *FileDownloader) DownloadFiles(files []FileDownload) error {
 	return d.DownloadFilesWithProgressUpdates(files, func(_ DownloadProgress) {})
 }
 
+// DownloadFilesWithProgressUpdates downloads the files in order and returns
+// the first error encountered.
 func (d *FileDownloader) DownloadFilesWithProgressUpdates(fileDownloads []FileDownload, callback DownloadProgressCallback) error {
+	results := d.downloadFiles(context.Background(), fileDownloads, callback, false)
+	for _, result := range results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+
+	return nil
+}
+
+// DownloadFilesWithResults downloads every file, continuing past failures, and
+// returns the result of each download in the same order as fileDownloads.
+func (d *FileDownloader) DownloadFilesWithResults(fileDownloads []FileDownload, callback DownloadProgressCallback) DownloadResults {
+	return d.downloadFiles(context.Background(), fileDownloads, callback, true)
+}
+
+// DownloadFilesWithResultsContext is like DownloadFilesWithResults, but it
+// stops when ctx is cancelled. Downloads in progress are aborted, and they and
+// any which had not started are reported as cancelled.
+func (d *FileDownloader) DownloadFilesWithResultsContext(ctx context.Context, fileDownloads []FileDownload, callback DownloadProgressCallback) DownloadResults {
+	return d.downloadFiles(ctx, fileDownloads, callback, true)
+}
+
+func (d *FileDownloader) downloadFiles(ctx context.Context, fileDownloads []FileDownload, callback DownloadProgressCallback, continueOnError bool) DownloadResults {
+	results := make(DownloadResults, len(fileDownloads))
+	jobs := make([]*downloadJob, len(fileDownloads))
+	for i, fileDownload := range fileDownloads {
+		results[i] = DownloadResult{FileDownload: fileDownload}
+		jobs[i] = &downloadJob{
+			ctx:          ctx,
+			fileDownload: fileDownload,
+			urls:         mirrorURLs(fileDownload),
+			tracker: &downloadProgressTracker{
+				callback:     callback,
+				fileDownload: fileDownload,
+				index:        i,
+				// The size is unknown until the file is probed.
+				contentLength: -1,
+			},
+		}
+	}
+
+	for _, job := range jobs {
+		job.tracker.setState(DownloadStateQueued)
+	}
+
+	fail := func(i int, err error) {
+		results[i].Status = DownloadStatusFailed
+		results[i].Err = err
+		jobs[i].tracker.fail(err)
+	}
+
+	cancel := func(i int) {
+		results[i].Status = DownloadStatusCancelled
+		results[i].Err = fmt.Errorf("download of %s was cancelled: %w", jobs[i].fileDownload.name(), ctx.Err())
+		jobs[i].tracker.cancel(results[i].Err)
+	}
+
+	skip := func(i int) {
+		results[i].Status = DownloadStatusUpToDate
+
+		var size int64
+		if fileInfo, err := os.Stat(jobs[i].fileDownload.FilePath); err == nil {
+			size = fileInfo.Size()
+		}
+		jobs[i].tracker.skip(size)
+	}
+
+	claimedFilePaths := map[string]bool{}
 	for _, fileDownload := range fileDownloads {
-		contentLength, err := d.getContentLength(fileDownload.URL, fileDownload.UseGetForContentLength)
+		if fileDownload.FilePath != "" {
+			claimedFilePaths[filepath.Clean(fileDownload.FilePath)] = true
+		}
+	}
+
+	for i, job := range jobs {
+		if ctx.Err() != nil {
+			cancel(i)
+			continue
+		}
+
+		job.tracker.setState(DownloadStateProbing)
+
+		// Files which are named after the response can only be requested
+		// conditionally once they have been named.
+		resolveFileName := job.fileDownload.FilePath == "" && job.fileDownload.Dir != "" && job.fileDownload.Sink == nil
+
+		var err error
+		if !resolveFileName {
+			job.conditions, err = d.conditionalRequestOptions(job.fileDownload)
+			if err != nil {
+				fail(i, fmt.Errorf("failed to get metadata of %s: %w", job.fileDownload.name(), err))
+				if !continueOnError {
+					return results[:i+1]
+				}
+				continue
+			}
+		}
+
+		job.mirror, err = tryMirrors(job.urls, func(url string) error {
+			job.tracker.setURL(url)
+
+			var err error
+			job.info, err = d.getContentInfo(url, job.fileDownload.UseGetForContentLength, job.requestOptions(job.conditions...)...)
+			return err
+		})
+		results[i].URL = job.urls[job.mirror]
+		if errors.Is(err, errNotModified) {
+			skip(i)
+			continue
+		}
+		if err != nil && ctx.Err() != nil {
+			cancel(i)
+			continue
+		}
+		if err != nil {
+			fail(i, fmt.Errorf("failed to get content size of %s: %w", job.fileDownload.name(), err))
+			if !continueOnError {
+				return results[:i+1]
+			}
+			continue
+		}
+
+		if resolveFileName {
+			job.fileDownload.FilePath = d.resolveFilePath(job, claimedFilePaths)
+			claimedFilePaths[job.fileDownload.FilePath] = true
+			job.tracker.setFileDownload(job.fileDownload)
+			results[i].FileDownload = job.fileDownload
+
+			job.conditions, err = d.conditionalRequestOptions(job.fileDownload)
+			if err != nil {
+				fail(i, fmt.Errorf("failed to get metadata of %s: %w", job.fileDownload.name(), err))
+				if !continueOnError {
+					return results[:i+1]
+				}
+				continue
+			}
+		}
+
+		job.tracker.setContentLength(job.info.contentLength)
+		job.tracker.setState(DownloadStateQueued)
+	}
+
+	concurrency := d.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+		// firstFailure is the index of the first failed download, after which
+		// no more downloads are started unless continueOnError is set.
+		firstFailure = -1
+		slots        = make(chan struct{}, concurrency)
+	)
+
+	for i, job := range jobs {
+		if results[i].Status != "" {
+			continue
+		}
+
+		slots <- struct{}{}
+
+		if ctx.Err() != nil {
+			<-slots
+			mu.Lock()
+			cancel(i)
+			mu.Unlock()
+			continue
+		}
+
+		mu.Lock()
+		stopped := firstFailure >= 0
+		mu.Unlock()
+		if stopped {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, job *downloadJob) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			startTime := time.Now()
+			err := d.downloadFile(job)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[i].Bytes = job.tracker.writtenBytes()
+			results[i].Duration = time.Since(startTime)
+			results[i].Attempts = job.tracker.attemptCount()
+			results[i].URL = job.urls[job.mirror]
+			if errors.Is(err, errNotModified) {
+				skip(i)
+				return
+			}
+			if err != nil && ctx.Err() != nil {
+				cancel(i)
+				return
+			}
+			if err != nil {
+				fail(i, fmt.Errorf("failed to download %s: %w", job.fileDownload.name(), err))
+				if !continueOnError && (firstFailure < 0 || i < firstFailure) {
+					firstFailure = i
+				}
+				return
+			}
+			results[i].Status = DownloadStatusSucceeded
+			job.tracker.finish()
+		}(i, job)
+	}
+	wg.Wait()
+
+	if firstFailure >= 0 {
+		return results[:firstFailure+1]
+	}
+
+	return results
+}
+
+func (d *FileDownloader) downloadFile(job *downloadJob) error {
+	fileDownload := job.fileDownload
+
+	var err error
+	job.checksums, err = d.expectedChecksums(job)
+	if err != nil {
+		return err
+	}
+
+	err = d.openOutput(job)
+	defer job.closeOutput()
+	if err != nil {
+		return err
+	}
+
+	verifiedPath := fileDownload.FilePath
+	if verifiedPath == "" {
+		verifiedPath = fileDownload.name()
+	}
+
+	segmented := d.shouldSegment(job)
+	if !segmented {
+		if err := d.resumePartial(job); err != nil {
+			return fmt.Errorf("failed to resume partial download: %w", err)
+		}
+	}
+
+	var (
+		failedURLs []string
+		errs       []error
+	)
+	for {
+		url := job.urls[job.mirror]
+		job.tracker.setURL(url)
+
+		if segmented {
+			err = d.downloadFileInSegments(job, url)
+		} else {
+			err = d.downloadFileWithCallback(job, url)
+		}
+		if err == nil && job.verifier.enabled() {
+			job.tracker.setState(DownloadStateVerifying)
+			if segmented {
+				err = d.hashSegments(job)
+			}
+			if err == nil {
+				err = job.verifier.verify(verifiedPath)
+			}
+		}
+		if err == nil || errors.Is(err, errNotModified) {
+			break
+		}
+		if job.ctx.Err() != nil {
+			if d.KeepPartial && !segmented {
+				if keepErr := d.keepPartial(job); keepErr != nil {
+					return fmt.Errorf("%w (failed to keep partial download: %s)", err, keepErr.Error())
+				}
+			}
+			return err
+		}
+
+		failedURLs = append(failedURLs, url)
+		errs = append(errs, err)
+		if job.mirror == len(job.urls)-1 {
+			break
+		}
+		job.mirror++
+		job.tracker.retry()
+
+		// A corrupt file or archive cannot be resumed, so it is downloaded
+		// again from the start.
+		var (
+			mismatchErr   *ChecksumMismatchError
+			extractionErr *extractionError
+		)
+		if errors.As(err, &mismatchErr) || errors.As(err, &extractionErr) {
+			if err := d.restart(job); err != nil {
+				return err
+			}
+		}
+	}
+	if errors.Is(err, errNotModified) {
+		return err
+	}
+	if err != nil {
+		var mismatchErr *ChecksumMismatchError
+		if errors.As(err, &mismatchErr) && job.output.file != nil {
+			if discardErr := d.discardFile(job.output.file); discardErr != nil {
+				errs[len(errs)-1] = fmt.Errorf("%w (%s)", err, discardErr.Error())
+			}
+		}
+		return mirrorsError(failedURLs, errs)
+	}
+
+	if job.extractor != nil {
+		job.tracker.setState(DownloadStateExtracting)
+	}
+
+	if job.extractor != nil && !job.extractor.streams() {
+		if err := job.extractor.extractFile(job.output.readerAt, job.tracker.writtenBytes()); err != nil {
+			return fmt.Errorf("failed to extract archive: %w", err)
+		}
+	}
+
+	if err := job.output.commit(); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+
+	if job.extractor != nil {
+		if err := job.extractor.commit(); err != nil {
+			return fmt.Errorf("failed to extract archive: %w", err)
+		}
+	}
+
+	if d.MetadataStore != nil && fileDownload.Sink == nil {
+		key, err := metadataKey(fileDownload.FilePath)
 		if err != nil {
-			return fmt.Errorf("failed to get content size of %s: %w", filepath.Base(fileDownload.FilePath), err)
+			return err
 		}
 
-		callback(DownloadProgress{
-			FileDownload: fileDownload,
-			TotalBytes:   contentLength,
+		err = d.MetadataStore.Put(key, FileMetadata{
+			URL:          fileDownload.URL,
+			ETag:         job.info.etag,
+			LastModified: job.info.lastModified,
+			Size:         job.tracker.writtenBytes(),
 		})
+		if err != nil {
+			return fmt.Errorf("failed to save metadata: %w", err)
+		}
 	}
 
-	for _, fileDownload := range fileDownloads {
-		err := d.downloadFileWithCallback(fileDownload, callback)
+	return nil
+}
+
+// requestOptions returns the options for a request made for the file,
+// followed by options.
+func (j *downloadJob) requestOptions(options ...RequestOption) []RequestOption {
+	jobOptions := []RequestOption{WithContext(j.ctx)}
+	if headers := j.fileDownload.options().Headers; len(headers) > 0 {
+		jobOptions = append(jobOptions, WithHeaders(headers))
+	}
+	return append(jobOptions, options...)
+}
+
+// openOutput prepares the output and extractor that a file is written to.
+func (d *FileDownloader) openOutput(job *downloadJob) error {
+	fileDownload := job.fileDownload
+
+	var err error
+	if fileDownload.Extract != nil {
+		job.extractor, err = newExtractor(fileDownload)
+		if err != nil {
+			return fmt.Errorf("failed to prepare extraction: %w", err)
+		}
+	}
+
+	if fileDownload.Sink == nil {
+		job.output, err = openFileOutput(fileDownload.FilePath)
 		if err != nil {
-			return fmt.Errorf("failed to download %s: %w", filepath.Base(fileDownload.FilePath), err)
+			return err
+		}
+	} else {
+		job.output, err = fileDownload.Sink.open(job.info.contentLength)
+		if err != nil {
+			return fmt.Errorf("failed to open sink: %w", err)
 		}
 	}
 
+	if job.extractor != nil && !job.extractor.streams() && job.output.readerAt == nil {
+		return fmt.Errorf("%s archives can only be extracted from sinks which implement io.ReaderAt", job.extractor.format)
+	}
+
 	return nil
 }
 
-func (d *FileDownloader) getContentLength(url string, useGet bool) (int64, error) {
+// closeOutput stops any extraction in progress and discards whatever has not
+// been committed. It is safe to defer.
+func (j *downloadJob) closeOutput() {
+	if j.stream != nil {
+		_ = j.stream.close(errDownloadAborted)
+	}
+	if j.extractor != nil {
+		j.extractor.abort()
+	}
+	if j.output != nil {
+		j.output.abort()
+	}
+}
+
+// restart discards everything written for a file so that it can be
+// downloaded again from the start.
+func (d *FileDownloader) restart(job *downloadJob) error {
+	job.closeOutput()
+	job.extractor = nil
+	job.output = nil
+	job.stream = nil
+	job.segments = nil
+	job.verifier = nil
+	job.tracker.reset()
+
+	return d.openOutput(job)
+}
+
+// keepPartial saves what has been written of a file whose download was
+// cancelled, along with where it came from, so that a later download can
+// resume from it. Nothing is kept for files that could not be resumed.
+func (d *FileDownloader) keepPartial(job *downloadJob) error {
+	if job.output.file == nil || job.extractor != nil || job.stream == nil || job.stream.offset == 0 {
+		return nil
+	}
+	if job.info.etag == "" && job.info.lastModified == "" {
+		return nil
+	}
+
+	metadata, err := json.Marshal(FileMetadata{
+		URL:          job.fileDownload.URL,
+		ETag:         job.info.etag,
+		LastModified: job.info.lastModified,
+		Size:         job.stream.offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	filePath := job.fileDownload.FilePath
+	if err := files.WriteFile(filePath+partialMetadataSuffix, metadata, 0644); err != nil {
+		return err
+	}
+
+	return job.output.file.CommitAs(filePath + partialFileSuffix)
+}
+
+// resumePartial continues the download of a file from what was kept of a
+// cancelled download, if the server still has the same file and supports
+// byte ranges. The kept files are removed either way, as they are either
+// copied into the new download or out of date.
+func (d *FileDownloader) resumePartial(job *downloadJob) error {
+	if job.output.file == nil || job.extractor != nil {
+		return nil
+	}
+
+	partialPath := job.fileDownload.FilePath + partialFileSuffix
+	metadataPath := job.fileDownload.FilePath + partialMetadataSuffix
+	rawMetadata, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = os.Remove(partialPath)
+		_ = os.Remove(metadataPath)
+	}()
+
+	var metadata FileMetadata
+	if err := json.Unmarshal(rawMetadata, &metadata); err != nil {
+		return nil
+	}
+
+	// Weak ETags do not guarantee that the bytes of the file are the same.
+	sameETag := metadata.ETag != "" && !strings.HasPrefix(metadata.ETag, "W/") && metadata.ETag == job.info.etag
+	sameLastModified := metadata.ETag == "" && metadata.LastModified != "" && metadata.LastModified == job.info.lastModified
+	if metadata.URL != job.fileDownload.URL || !job.info.acceptsRanges || !(sameETag || sameLastModified) {
+		return nil
+	}
+	if job.info.contentLength >= 0 && metadata.Size >= job.info.contentLength {
+		return nil
+	}
+
+	partial, err := os.Open(partialPath)
+	if err != nil {
+		return nil
+	}
+	defer partial.Close()
+
+	if err := d.startStream(job, job.info); err != nil {
+		return err
+	}
+
+	written, err := io.Copy(job.stream.writer, io.LimitReader(partial, metadata.Size))
+	job.stream.offset = written
+	if err != nil {
+		return err
+	}
+	if written != metadata.Size {
+		// The partial file was truncated, so the file is downloaded from the
+		// start.
+		return d.restart(job)
+	}
+
+	return nil
+}
+
+// mirrorURLs returns the URLs a file can be downloaded from, in the order they
+// are tried.
+func mirrorURLs(fileDownload FileDownload) []string {
+	urls := append([]string{fileDownload.URL}, fileDownload.options().Mirrors...)
+	if fileDownload.options().ShuffleMirrors {
+		random := rand.New(rand.NewSource(time.Now().UnixNano()))
+		random.Shuffle(len(urls), func(i, j int) {
+			urls[i], urls[j] = urls[j], urls[i]
+		})
+	}
+	return urls
+}
+
+// tryMirrors calls fn with each URL in turn until it succeeds or reports that
+// the file has not been modified, and returns the index of the last URL tried.
+func tryMirrors(urls []string, fn func(url string) error) (int, error) {
+	var errs []error
+	for i, url := range urls {
+		err := fn(url)
+		if err == nil || errors.Is(err, errNotModified) {
+			return i, err
+		}
+		errs = append(errs, err)
+	}
+
+	return len(urls) - 1, mirrorsError(urls, errs)
+}
+
+// mirrorsError combines the errors from every mirror that was tried. A single
+// error is returned as is.
+func mirrorsError(urls []string, errs []error) error {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+
+	mirrorErrs := make([]error, len(errs))
+	for i, err := range errs {
+		mirrorErrs[i] = fmt.Errorf("%s: %w", urls[i], err)
+	}
+
+	return fmt.Errorf("all mirrors failed: %w", &downloadErrors{errs: mirrorErrs})
+}
+
+// resolveFilePath names a file in its Dir after the response it was probed
+// with, avoiding paths which are claimed by other downloads or already exist.
+// Existing files are reused if they were downloaded from the same URL, so that
+// they can be requested conditionally, or if ReplaceExisting is set.
+func (d *FileDownloader) resolveFilePath(job *downloadJob, claimedFilePaths map[string]bool) string {
+	name := job.info.fileName
+	if name == "" {
+		name = fileNameFromURL(job.fileDownload.URL)
+	}
+	if name == "" {
+		name = defaultFileName
+	}
+
+	return uniqueFilePath(filepath.Join(job.fileDownload.Dir, name), func(filePath string) bool {
+		if claimedFilePaths[filePath] {
+			return true
+		}
+		if d.ReplaceExisting {
+			return false
+		}
+
+		if _, err := os.Lstat(filePath); err != nil {
+			return !errors.Is(err, fs.ErrNotExist)
+		}
+
+		return !d.downloadedFrom(filePath, job.fileDownload.URL)
+	})
+}
+
+// downloadedFrom reports whether the metadata store records filePath as
+// having been downloaded from url.
+func (d *FileDownloader) downloadedFrom(filePath, url string) bool {
+	if d.MetadataStore == nil {
+		return false
+	}
+
+	key, err := metadataKey(filePath)
+	if err != nil {
+		return false
+	}
+
+	metadata, found, err := d.MetadataStore.Get(key)
+	return err == nil && found && metadata.URL == url
+}
+
+// conditionalRequestOptions returns the headers to conditionally request a file
+// that has previously been downloaded, as long as the local copy still matches
+// the stored metadata.
+func (d *FileDownloader) conditionalRequestOptions(fileDownload FileDownload) ([]RequestOption, error) {
+	if d.MetadataStore == nil || d.Force || fileDownload.Sink != nil {
+		return nil, nil
+	}
+
+	key, err := metadataKey(fileDownload.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, found, err := d.MetadataStore.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found || metadata.URL != fileDownload.URL {
+		return nil, nil
+	}
+
+	fileInfo, err := os.Stat(fileDownload.FilePath)
+	if err != nil || fileInfo.Size() != metadata.Size {
+		return nil, nil
+	}
+
+	var options []RequestOption
+	if metadata.ETag != "" {
+		options = append(options, WithHeader("If-None-Match", metadata.ETag))
+	}
+	if metadata.LastModified != "" {
+		options = append(options, WithHeader("If-Modified-Since", metadata.LastModified))
+	}
+
+	return options, nil
+}
+
+func metadataKey(filePath string) (string, error) {
+	key, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return key, nil
+}
+
+func (d *FileDownloader) expectedChecksums(job *downloadJob) ([]Checksum, error) {
+	checksums := append([]Checksum{}, job.fileDownload.options().Checksums...)
+
+	if job.fileDownload.options().FetchChecksumFile {
+		var digest string
+		_, err := tryMirrors(job.urls, func(url string) error {
+			var err error
+			digest, err = d.fetchChecksumFile(url+".sha256", job.requestOptions()...)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get checksum file: %w", err)
+		}
+		checksums = append(checksums, Checksum{Algorithm: SHA256, Digest: digest})
+	}
+
+	return checksums, nil
+}
+
+func (d *FileDownloader) fetchChecksumFile(checksumURL string, options ...RequestOption) (string, error) {
+	resp, err := d.Browser.Get(checksumURL, options...)
+	if err != nil {
+		return "", fmt.Errorf("failed to do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("got non-2XX response: %s", resp.Status)
+	}
+
+	fileName := path.Base(strings.TrimSuffix(resp.Request.URL.Path, ".sha256"))
+
+	return parseChecksumFile(resp.Body, fileName)
+}
+
+// discardFile removes a file that failed verification, or moves it into the
+// quarantine directory if one is configured.
+func (d *FileDownloader) discardFile(file *files.AtomicFile) error {
+	if d.QuarantineDir == "" {
+		if err := file.Abort(); err != nil {
+			return fmt.Errorf("failed to remove file: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(d.QuarantineDir, 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	if err := file.CommitAs(filepath.Join(d.QuarantineDir, filepath.Base(file.Path()))); err != nil {
+		return fmt.Errorf("failed to quarantine file: %w", err)
+	}
+
+	return nil
+}
+
+func (d *FileDownloader) getContentInfo(url string, useGet bool, options ...RequestOption) (contentInfo, error) {
 	var (
 		resp *http.Response
 		err  error
 	)
 	if useGet {
-		resp, err = d.Browser.Get(url)
+		resp, err = d.Browser.Get(url, options...)
 	} else {
-		resp, err = d.Browser.Head(url)
+		resp, err = d.Browser.Head(url, options...)
 	}
 	if err != nil {
-		return 0, fmt.Errorf("failed to do request: %w", err)
+		return contentInfo{}, fmt.Errorf("failed to do request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return contentInfo{}, errNotModified
+	}
+
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return 0, fmt.Errorf("got non-2XX response: %s", resp.Status)
+		return contentInfo{}, fmt.Errorf("got non-2XX response: %s", resp.Status)
 	}
 
-	return resp.ContentLength, nil
+	return responseContentInfo(resp), nil
+}
+
+func responseContentInfo(resp *http.Response) contentInfo {
+	return contentInfo{
+		contentLength: resp.ContentLength,
+		acceptsRanges: acceptsByteRanges(resp.Header),
+		checksums:     checksumsFromHeaders(resp.Header),
+		etag:          resp.Header.Get("ETag"),
+		lastModified:  resp.Header.Get("Last-Modified"),
+		fileName:      fileNameFromResponse(resp),
+	}
+}
+
+func acceptsByteRanges(header http.Header) bool {
+	for _, value := range strings.Split(header.Get("Accept-Ranges"), ",") {
+		if strings.TrimSpace(value) == "bytes" {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldSegment reports whether the file can be downloaded in parallel byte
+// ranges. Archives which are extracted as they are downloaded need to be
+// received in order, so they are never segmented.
+func (d *FileDownloader) shouldSegment(job *downloadJob) bool {
+	if job.extractor != nil && job.extractor.streams() {
+		return false
+	}
+	if !job.output.segmentable(len(job.checksums) > 0 || (job.fileDownload.options().UseDigestHeaders && len(job.info.checksums) > 0)) {
+		return false
+	}
+	return d.Segments > 1 && job.info.acceptsRanges && job.info.contentLength >= int64(d.Segments)
 }
 
-func (d *FileDownloader) downloadFileWithCallback(fileDownload FileDownload, callback DownloadProgressCallback) error {
-	resp, err := d.Browser.Get(fileDownload.URL)
+func (d *FileDownloader) downloadFileWithCallback(job *downloadJob, url string) error {
+	resuming := job.stream != nil && job.stream.offset > 0
+
+	options := job.requestOptions(withAttemptHook(job.tracker.attempt))
+	if resuming {
+		options = append(options, WithHeader("Range", fmt.Sprintf("bytes=%d-", job.stream.offset)))
+	} else {
+		options = append(options, job.conditions...)
+	}
+
+	resp, err := d.Browser.Get(url, options...)
 	if err != nil {
 		return fmt.Errorf("failed to do request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("got non-2XX response: %s", resp.Status)
+	if resp.StatusCode == http.StatusNotModified {
+		return errNotModified
+	}
+
+	if resuming && resp.StatusCode == http.StatusOK {
+		// The mirror ignored the range, so the file starts over.
+		resuming = false
 	}
 
-	file, err := os.OpenFile(fileDownload.FilePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if resuming {
+		if resp.StatusCode != http.StatusPartialContent {
+			return fmt.Errorf("expected 206 response to range request, got: %s", resp.Status)
+		}
+		if start, ok := contentRangeStart(resp.Header); !ok || start != job.stream.offset {
+			return fmt.Errorf("got unexpected content range: %q", resp.Header.Get("Content-Range"))
+		}
+	} else {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("got non-2XX response: %s", resp.Status)
+		}
+
+		if job.stream != nil {
+			if err := d.restart(job); err != nil {
+				return err
+			}
+		}
+
+		if err := d.startStream(job, responseContentInfo(resp)); err != nil {
+			return err
+		}
+	}
+
+	written, err := io.Copy(job.stream.writer, resp.Body)
+	job.stream.offset += written
 	if err != nil {
-		return fmt.Errorf("failed to open output file: %w", err)
+		// When extraction fails, its error is what stops the copy.
+		var extractionErr *extractionError
+		if errors.As(err, &extractionErr) {
+			return fmt.Errorf("failed to extract archive: %w", extractionErr)
+		}
+		return fmt.Errorf("failed to write to file: %w", err)
 	}
-	defer file.Close()
 
-	_, err = io.Copy(&downloadProgressWriter{
-		writer:        file,
-		callback:      callback,
-		fileDownload:  fileDownload,
-		contentLength: resp.ContentLength,
-	}, resp.Body)
+	if contentLength := job.tracker.totalBytes(); contentLength >= 0 && job.stream.offset != contentLength {
+		return fmt.Errorf("expected %d bytes, got %d: %w", contentLength, job.stream.offset, io.ErrUnexpectedEOF)
+	}
+
+	if err := job.stream.close(nil); err != nil {
+		return fmt.Errorf("failed to extract archive: %w", &extractionError{err: err})
+	}
+
+	return nil
+}
+
+// startStream sets up the writers that a file downloaded in a single stream
+// goes through, based on the response it is downloaded from.
+func (d *FileDownloader) startStream(job *downloadJob, info contentInfo) error {
+	checksums := append([]Checksum{}, job.checksums...)
+	if job.fileDownload.options().UseDigestHeaders {
+		checksums = append(checksums, info.checksums...)
+	}
+
+	var err error
+	job.verifier, err = newChecksumVerifier(checksums)
+	if err != nil {
+		return err
+	}
+
+	job.info.etag = info.etag
+	job.info.lastModified = info.lastModified
+	job.tracker.setContentLength(info.contentLength)
+
+	writers := []io.Writer{job.output.writer}
+	if job.verifier.enabled() {
+		writers = append(writers, job.verifier)
+	}
+
+	stream := &downloadStream{}
+	if job.extractor != nil && job.extractor.streams() {
+		extractionReader, extractionWriter := io.Pipe()
+		stream.extractionWriter = extractionWriter
+		stream.extractionDone = make(chan error, 1)
+		go func() {
+			err := job.extractor.extractStream(extractionReader)
+			if err != nil {
+				extractionReader.CloseWithError(&extractionError{err: err})
+			}
+			stream.extractionDone <- err
+		}()
+		writers = append(writers, extractionWriter)
+	}
+	stream.writer = &downloadProgressWriter{writer: io.MultiWriter(writers...), tracker: job.tracker}
+
+	job.stream = stream
+
+	return nil
+}
+
+// downloadStream is the pipeline a file downloaded in a single stream is
+// written through. It outlives any one response, so that the download can be
+// resumed from a mirror.
+type downloadStream struct {
+	writer           io.Writer
+	offset           int64
+	extractionWriter *io.PipeWriter
+	extractionDone   chan error
+}
+
+// close ends the stream with err and waits for extraction to finish, returning
+// its error.
+func (s *downloadStream) close(err error) error {
+	if s.extractionWriter == nil {
+		return nil
+	}
+
+	s.extractionWriter.CloseWithError(err)
+	s.extractionWriter = nil
+
+	return <-s.extractionDone
+}
+
+// extractionError marks an error from the extraction of a streamed archive.
+type extractionError struct {
+	err error
+}
+
+func (e *extractionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *extractionError) Unwrap() error {
+	return e.err
+}
+
+// contentRangeStart returns the position of the first byte in a Content-Range
+// header.
+func contentRangeStart(header http.Header) (int64, bool) {
+	value := strings.TrimPrefix(header.Get("Content-Range"), "bytes ")
+
+	dash := strings.Index(value, "-")
+	if dash < 0 {
+		return 0, false
+	}
+
+	start, err := strconv.ParseInt(value[:dash], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return start, true
+}
+
+// segment is a byte range of a file, from the next byte to download through to
+// end.
+type segment struct {
+	offset int64
+	end    int64
+}
+
+func (s *segment) done() bool {
+	return s.offset > s.end
+}
+
+func (d *FileDownloader) downloadFileInSegments(job *downloadJob, url string) error {
+	contentLength := job.info.contentLength
+
+	if job.segments == nil {
+		if job.output.file != nil {
+			if err := job.output.file.Truncate(contentLength); err != nil {
+				return fmt.Errorf("failed to allocate output file: %w", err)
+			}
+		}
+
+		job.tracker.setContentLength(contentLength)
+
+		segmentSize := (contentLength + int64(d.Segments) - 1) / int64(d.Segments)
+		for i := 0; i < d.Segments; i++ {
+			start := int64(i) * segmentSize
+			end := start + segmentSize - 1
+			if end > contentLength-1 {
+				end = contentLength - 1
+			}
+			if start > end {
+				continue
+			}
+
+			job.segments = append(job.segments, &segment{offset: start, end: end})
+		}
+	}
+
+	// Segments which were completed from a previous mirror are kept.
+	var wg sync.WaitGroup
+	errs := make([]error, len(job.segments))
+	for i, seg := range job.segments {
+		if seg.done() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, seg *segment) {
+			defer wg.Done()
+			errs[i] = d.downloadSegment(job, url, seg)
+		}(i, seg)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("failed to download segment %d: %w", i+1, err)
+		}
+	}
+
+	checksums := append([]Checksum{}, job.checksums...)
+	if job.fileDownload.options().UseDigestHeaders {
+		checksums = append(checksums, job.info.checksums...)
+	}
+
+	var err error
+	job.verifier, err = newChecksumVerifier(checksums)
+	return err
+}
+
+// hashSegments hashes a file which was downloaded in segments. Segments arrive
+// out of order, so the file can only be hashed once all of them have been
+// written.
+func (d *FileDownloader) hashSegments(job *downloadJob) error {
+	if _, err := io.Copy(job.verifier, io.NewSectionReader(job.output.readerAt, 0, job.info.contentLength)); err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	return nil
+}
+
+func (d *FileDownloader) downloadSegment(job *downloadJob, url string, seg *segment) error {
+	for attempt := 0; ; attempt++ {
+		writer := &segmentWriter{writer: job.output.writerAt, offset: seg.offset, tracker: job.tracker}
+		err := d.downloadRange(url, writer, seg.offset, seg.end, job.requestOptions(withAttemptHook(job.tracker.attempt))...)
+		seg.offset = writer.offset
+		if err == nil {
+			return nil
+		}
+		if attempt >= d.SegmentRetries || job.ctx.Err() != nil {
+			return err
+		}
+		job.tracker.retry()
+	}
+}
+
+func (d *FileDownloader) downloadRange(url string, writer io.Writer, start, end int64, options ...RequestOption) error {
+	options = append(options, WithHeader("Range", fmt.Sprintf("bytes=%d-%d", start, end)))
+	resp, err := d.Browser.Get(url, options...)
+	if err != nil {
+		return fmt.Errorf("failed to do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("expected 206 response to range request, got: %s", resp.Status)
+	}
+
+	written, err := io.Copy(writer, resp.Body)
 	if err != nil {
 		return fmt.Errorf("failed to write to file: %w", err)
 	}
+	if written != end-start+1 {
+		return fmt.Errorf("expected %d bytes, got %d: %w", end-start+1, written, io.ErrUnexpectedEOF)
+	}
 
 	return nil
 }
 
 type downloadProgressWriter struct {
-	writer            io.Writer
-	callback          DownloadProgressCallback
-	fileDownload      FileDownload
-	contentLength     int64
-	totalWrittenBytes int64
-	firstWrite        time.Time
+	writer  io.Writer
+	tracker *downloadProgressTracker
 }
 
 func (w *downloadProgressWriter) Write(bytes []byte) (int, error) {
-	if w.firstWrite.IsZero() {
-		w.firstWrite = time.Now()
-	}
+	w.tracker.start()
 
 	writtenBits, err := w.writer.Write(bytes)
 	if err != nil {
 		return 0, err
 	}
 
-	now := time.Now()
+	w.tracker.add(writtenBits)
 
-	w.totalWrittenBytes += int64(writtenBits)
+	return writtenBits, nil
+}
 
-	w.callback(DownloadProgress{
-		FileDownload:               w.fileDownload,
-		TotalBytes:                 w.contentLength,
-		DownloadedBytes:            w.totalWrittenBytes,
-		AverageBytesPerMicrosecond: float64(w.totalWrittenBytes) / float64(now.Sub(w.firstWrite).Microseconds()),
-		DownloadTime:               now.Sub(w.firstWrite),
-	})
+// segmentWriter writes sequentially to writer starting at offset, keeping
+// track of how far it got so that a failed segment can be resumed.
+type segmentWriter struct {
+	writer  io.WriterAt
+	offset  int64
+	tracker *downloadProgressTracker
+}
+
+func (w *segmentWriter) Write(bytes []byte) (int, error) {
+	w.tracker.start()
+
+	writtenBits, err := w.writer.WriteAt(bytes, w.offset)
+	w.offset += int64(writtenBits)
+	if writtenBits > 0 {
+		w.tracker.add(writtenBits)
+	}
+	if err != nil {
+		return writtenBits, err
+	}
 
 	return writtenBits, nil
 }
@@ -0,0 +1,1526 @@
+package net_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	gocontext "context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileDownloader(t *testing.T) {
+	spec.Run(t, "FileDownloader", testFileDownloader, spec.Report(report.Terminal{}))
+}
+
+func testFileDownloader(t *testing.T, context spec.G, it spec.S) {
+	var (
+		server  *httptest.Server
+		handler *fileServerHandler
+		tempDir string
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		handler = &fileServerHandler{content: bytes.Repeat([]byte("0123456789"), 1000)}
+		server = httptest.NewServer(handler)
+		tempDir = t.TempDir()
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("downloads files", func() {
+		downloader := net.FileDownloader{Browser: net.NewBrowser()}
+		filePath := filepath.Join(tempDir, "some-file")
+
+		err := downloader.DownloadFiles([]net.FileDownload{{URL: server.URL + "/some-file", FilePath: filePath}})
+		require.NoError(err)
+
+		contents, err := os.ReadFile(filePath)
+		require.NoError(err)
+		assert.Equal(handler.content, contents)
+		assert.Empty(handler.ranges())
+	})
+
+	it("does not replace the file until the download is complete", func() {
+		handler.failRanges = map[string]int{"bytes=5000-9999": 1}
+		downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 2}
+		filePath := filepath.Join(tempDir, "some-file")
+		require.NoError(os.WriteFile(filePath, []byte("old-contents"), 0644))
+
+		err := downloader.DownloadFiles([]net.FileDownload{{URL: server.URL + "/some-file", FilePath: filePath}})
+		require.Error(err)
+
+		contents, err := os.ReadFile(filePath)
+		require.NoError(err)
+		assert.Equal("old-contents", string(contents))
+
+		entries, err := os.ReadDir(tempDir)
+		require.NoError(err)
+		assert.Len(entries, 1)
+	})
+
+	it("sends the headers of the file with every request", func() {
+		handler.requiredHeaders = map[string]string{"Authorization": "Bearer some-token"}
+		handler.otherFiles = map[string][]byte{"/some-file.sha256": []byte(sha256Hex(handler.content) + "  some-file\n")}
+		downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 2}
+		filePath := filepath.Join(tempDir, "some-file")
+
+		err := downloader.DownloadFiles([]net.FileDownload{{
+			URL:      server.URL + "/some-file",
+			FilePath: filePath,
+			Options: &net.FileDownloadOptions{
+				FetchChecksumFile: true,
+				Headers:           map[string]string{"Authorization": "Bearer some-token"},
+			},
+		}})
+		require.NoError(err)
+		assert.Len(handler.ranges(), 2)
+		assert.FileExists(filePath)
+	})
+
+	it("downloads files of unknown size", func() {
+		handler.unknownLength = true
+		downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 4}
+		filePath := filepath.Join(tempDir, "some-file")
+
+		var progresses []net.DownloadProgress
+		err := downloader.DownloadFilesWithProgressUpdates([]net.FileDownload{{URL: server.URL + "/some-file", FilePath: filePath}}, func(progress net.DownloadProgress) {
+			progresses = append(progresses, progress)
+		})
+		require.NoError(err)
+
+		require.Greater(len(progresses), 2)
+		for _, progress := range progresses[:len(progresses)-1] {
+			assert.Equal(int64(-1), progress.TotalBytes)
+			assert.False(progress.Done)
+		}
+
+		lastProgress := progresses[len(progresses)-1]
+		assert.True(lastProgress.Done)
+		assert.Equal(int64(10000), lastProgress.TotalBytes)
+		assert.Equal(int64(10000), lastProgress.DownloadedBytes)
+		assert.Empty(handler.ranges())
+
+		contents, err := os.ReadFile(filePath)
+		require.NoError(err)
+		assert.Equal(handler.content, contents)
+	})
+
+	it("can be used as a map key", func() {
+		download := net.FileDownload{
+			URL:      server.URL + "/some-file",
+			FilePath: filepath.Join(tempDir, "some-file"),
+			Options:  &net.FileDownloadOptions{Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: "some-digest"}}},
+		}
+
+		downloads := map[net.FileDownload]bool{download: true}
+		assert.True(downloads[download])
+	})
+
+	context("DownloadFilesWithResults", func() {
+		it("continues past failures and reports the result of each download", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+			handler.statusCodes = map[string]int{"/missing-file": http.StatusNotFound}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-file")},
+				{URL: server.URL + "/missing-file", FilePath: filepath.Join(tempDir, "missing-file"), UseGetForContentLength: true},
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "missing-dir", "some-other-file")},
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-other-file")},
+			}, func(_ net.DownloadProgress) {})
+			require.Len(results, 4)
+
+			assert.Equal(net.DownloadStatusSucceeded, results[0].Status)
+			assert.Equal(int64(10000), results[0].Bytes)
+			assert.Equal(1, results[0].Attempts)
+			assert.NoError(results[0].Err)
+
+			assert.Equal(net.DownloadStatusFailed, results[1].Status)
+			assert.EqualError(results[1].Err, "failed to get content size of missing-file: got non-2XX response: 404 Not Found")
+
+			assert.Equal(net.DownloadStatusFailed, results[2].Status)
+			assert.ErrorIs(results[2].Err, os.ErrNotExist)
+
+			assert.Equal(net.DownloadStatusSucceeded, results[3].Status)
+			assert.FileExists(filepath.Join(tempDir, "some-other-file"))
+
+			assert.Len(results.Failed(), 2)
+			err := results.Err()
+			assert.ErrorIs(err, os.ErrNotExist)
+			assert.Contains(err.Error(), "missing-file")
+
+			var summary strings.Builder
+			require.NoError(results.WriteSummary(&summary))
+			assert.Regexp(`some-file\s+succeeded\s+9\.77KB`, summary.String())
+			assert.Regexp(`missing-file\s+failed\s+0B`, summary.String())
+		})
+
+		it("counts retried requests as attempts", func() {
+			handler.failRanges = map[string]int{"bytes=5000-9999": 1}
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 2, SegmentRetries: 1}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-file")},
+			}, func(_ net.DownloadProgress) {})
+
+			require.NoError(results.Err())
+			assert.Equal(3, results[0].Attempts)
+		})
+	})
+
+	context("with Concurrency", func() {
+		it("downloads several files at once", func() {
+			handler.getDelay = 50 * time.Millisecond
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Concurrency: 2}
+
+			var fileDownloads []net.FileDownload
+			for i := 0; i < 4; i++ {
+				fileDownloads = append(fileDownloads, net.FileDownload{
+					URL:      server.URL + "/some-file",
+					FilePath: filepath.Join(tempDir, fmt.Sprintf("some-file-%d", i)),
+				})
+			}
+
+			results := downloader.DownloadFilesWithResults(fileDownloads, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			require.Len(results, 4)
+			for i, result := range results {
+				assert.Equal(net.DownloadStatusSucceeded, result.Status)
+				assert.FileExists(filepath.Join(tempDir, fmt.Sprintf("some-file-%d", i)))
+			}
+
+			handler.mu.Lock()
+			defer handler.mu.Unlock()
+			assert.Equal(2, handler.maxActiveGets)
+		})
+
+		it("stops starting downloads after a failure", func() {
+			handler.getDelay = 50 * time.Millisecond
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Concurrency: 2}
+
+			err := downloader.DownloadFiles([]net.FileDownload{
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "missing-dir", "some-file")},
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-file")},
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-other-file")},
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-third-file")},
+			})
+			assert.ErrorIs(err, os.ErrNotExist)
+			assert.NoFileExists(filepath.Join(tempDir, "some-third-file"))
+		})
+	})
+
+	context("when the context is cancelled", func() {
+		var (
+			ctx    gocontext.Context
+			cancel gocontext.CancelFunc
+			// cancelAtStall cancels the context once the server has stalled.
+			cancelAtStall net.DownloadProgressCallback
+		)
+
+		it.Before(func() {
+			handler.stallAt = 4000
+			handler.headers = map[string]string{"ETag": `"some-etag"`}
+
+			ctx, cancel = gocontext.WithCancel(gocontext.Background())
+			cancelAtStall = func(progress net.DownloadProgress) {
+				if progress.DownloadedBytes >= int64(handler.stallAt) {
+					cancel()
+				}
+			}
+		})
+
+		it.After(func() {
+			cancel()
+		})
+
+		it("cancels downloads in progress and ones which have not started", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+			filePath := filepath.Join(tempDir, "some-file")
+
+			var states []net.DownloadState
+			results := downloader.DownloadFilesWithResultsContext(ctx, []net.FileDownload{
+				{URL: server.URL + "/some-file", FilePath: filePath},
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-other-file")},
+			}, func(progress net.DownloadProgress) {
+				if progress.Index == 1 {
+					states = append(states, progress.State)
+				}
+				cancelAtStall(progress)
+			})
+			require.Len(results, 2)
+
+			for _, result := range results {
+				assert.Equal(net.DownloadStatusCancelled, result.Status)
+				assert.ErrorIs(result.Err, gocontext.Canceled)
+			}
+			assert.Equal(int64(4000), results[0].Bytes)
+			assert.Len(results.Cancelled(), 2)
+			assert.Empty(results.Failed())
+			assert.Equal(net.DownloadStateCancelled, states[len(states)-1])
+
+			entries, err := os.ReadDir(tempDir)
+			require.NoError(err)
+			assert.Empty(entries)
+		})
+
+		it("does not make any requests if it is already cancelled", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+			cancel()
+
+			results := downloader.DownloadFilesWithResultsContext(ctx, []net.FileDownload{
+				{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-file")},
+			}, func(_ net.DownloadProgress) {})
+			require.Len(results, 1)
+			assert.Equal(net.DownloadStatusCancelled, results[0].Status)
+			assert.Equal(0, handler.maxActiveGets)
+		})
+
+		context("with KeepPartial", func() {
+			it("keeps what was downloaded and resumes from it", func() {
+				downloader := net.FileDownloader{Browser: net.NewBrowser(), KeepPartial: true}
+				filePath := filepath.Join(tempDir, "some-file")
+				fileDownloads := []net.FileDownload{{URL: server.URL + "/some-file", FilePath: filePath}}
+
+				results := downloader.DownloadFilesWithResultsContext(ctx, fileDownloads, cancelAtStall)
+				require.Equal(net.DownloadStatusCancelled, results[0].Status)
+
+				assert.NoFileExists(filePath)
+				partial, err := os.ReadFile(filePath + ".partial")
+				require.NoError(err)
+				assert.Equal(handler.content[:4000], partial)
+
+				handler.stallAt = 0
+				results = downloader.DownloadFilesWithResults(fileDownloads, func(_ net.DownloadProgress) {})
+				require.NoError(results.Err())
+
+				contents, err := os.ReadFile(filePath)
+				require.NoError(err)
+				assert.Equal(handler.content, contents)
+				assert.Equal([]string{"bytes=4000-"}, handler.requestRanges)
+				assert.NoFileExists(filePath + ".partial")
+				assert.NoFileExists(filePath + ".partial.json")
+			})
+
+			it("downloads the whole file if it has changed", func() {
+				downloader := net.FileDownloader{Browser: net.NewBrowser(), KeepPartial: true}
+				filePath := filepath.Join(tempDir, "some-file")
+				fileDownloads := []net.FileDownload{{URL: server.URL + "/some-file", FilePath: filePath}}
+
+				results := downloader.DownloadFilesWithResultsContext(ctx, fileDownloads, cancelAtStall)
+				require.Equal(net.DownloadStatusCancelled, results[0].Status)
+				require.FileExists(filePath + ".partial")
+
+				handler.stallAt = 0
+				handler.headers["ETag"] = `"some-other-etag"`
+				results = downloader.DownloadFilesWithResults(fileDownloads, func(_ net.DownloadProgress) {})
+				require.NoError(results.Err())
+
+				contents, err := os.ReadFile(filePath)
+				require.NoError(err)
+				assert.Equal(handler.content, contents)
+				assert.Empty(handler.requestRanges)
+				assert.NoFileExists(filePath + ".partial")
+			})
+		})
+	})
+
+	context("progress", func() {
+		it("reports each state of the download", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			var progresses []net.DownloadProgress
+			err := downloader.DownloadFilesWithProgressUpdates([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filepath.Join(tempDir, "some-file"),
+				Options: &net.FileDownloadOptions{
+					Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: sha256Hex(handler.content)}},
+				},
+			}}, func(progress net.DownloadProgress) { progresses = append(progresses, progress) })
+			require.NoError(err)
+
+			var states []net.DownloadState
+			for _, progress := range progresses {
+				if len(states) == 0 || states[len(states)-1] != progress.State {
+					states = append(states, progress.State)
+				}
+			}
+			assert.Equal([]net.DownloadState{
+				net.DownloadStateQueued,
+				net.DownloadStateProbing,
+				net.DownloadStateQueued,
+				net.DownloadStateConnecting,
+				net.DownloadStateDownloading,
+				net.DownloadStateVerifying,
+				net.DownloadStateDone,
+			}, states)
+
+			lastProgress := progresses[len(progresses)-1]
+			assert.True(lastProgress.Done)
+			assert.Equal(1, lastProgress.Attempts)
+			assert.Greater(lastProgress.BytesPerSecond, float64(0))
+			assert.Zero(lastProgress.ETA)
+		})
+
+		it("estimates the time remaining while downloading", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			var progresses []net.DownloadProgress
+			err := downloader.DownloadFilesWithProgressUpdates([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filepath.Join(tempDir, "some-file"),
+			}}, func(progress net.DownloadProgress) { progresses = append(progresses, progress) })
+			require.NoError(err)
+
+			for _, progress := range progresses {
+				if progress.State != net.DownloadStateDownloading {
+					continue
+				}
+				assert.Greater(progress.BytesPerSecond, float64(0))
+				assert.GreaterOrEqual(progress.ETA, time.Duration(0))
+				if progress.DownloadedBytes == progress.TotalBytes {
+					assert.Zero(progress.ETA)
+				}
+			}
+		})
+
+		it("reports retries", func() {
+			handler.failRanges = map[string]int{"bytes=5000-9999": 1}
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 2, SegmentRetries: 1}
+
+			var (
+				mu         sync.Mutex
+				progresses []net.DownloadProgress
+			)
+			err := downloader.DownloadFilesWithProgressUpdates([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filepath.Join(tempDir, "some-file"),
+			}}, func(progress net.DownloadProgress) {
+				mu.Lock()
+				defer mu.Unlock()
+				progresses = append(progresses, progress)
+			})
+			require.NoError(err)
+
+			var retried bool
+			for _, progress := range progresses {
+				retried = retried || progress.State == net.DownloadStateRetrying
+			}
+			assert.True(retried)
+			assert.Equal(3, progresses[len(progresses)-1].Attempts)
+		})
+
+		it("reports why a download failed", func() {
+			handler.statusCodes = map[string]int{"/missing-file": http.StatusNotFound}
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			var progresses []net.DownloadProgress
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{{
+				URL:      server.URL + "/missing-file",
+				FilePath: filepath.Join(tempDir, "missing-file"),
+			}}, func(progress net.DownloadProgress) { progresses = append(progresses, progress) })
+			require.Error(results.Err())
+
+			lastProgress := progresses[len(progresses)-1]
+			assert.Equal(net.DownloadStateFailed, lastProgress.State)
+			assert.Equal(results[0].Err, lastProgress.Err)
+			assert.False(lastProgress.Done)
+		})
+	})
+
+	context("with a MetadataStore", func() {
+		var (
+			downloader   net.FileDownloader
+			fileDownload net.FileDownload
+		)
+
+		it.Before(func() {
+			store, err := net.NewJSONMetadataStore(filepath.Join(tempDir, "metadata.json"))
+			require.NoError(err)
+
+			handler.headers = map[string]string{"ETag": `"some-etag"`}
+			downloader = net.FileDownloader{Browser: net.NewBrowser(), MetadataStore: store}
+			fileDownload = net.FileDownload{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-file")}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{fileDownload}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			require.Equal(net.DownloadStatusSucceeded, results[0].Status)
+		})
+
+		it("skips files that have not been modified", func() {
+			var progresses []net.DownloadProgress
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{fileDownload}, func(progress net.DownloadProgress) {
+				progresses = append(progresses, progress)
+			})
+			require.NoError(results.Err())
+
+			assert.Equal(net.DownloadStatusUpToDate, results[0].Status)
+			require.NotEmpty(progresses)
+			for _, progress := range progresses {
+				assert.NotEqual(net.DownloadStateDownloading, progress.State)
+			}
+
+			lastProgress := progresses[len(progresses)-1]
+			assert.Equal(net.DownloadStateSkipped, lastProgress.State)
+			assert.True(lastProgress.UpToDate)
+			assert.Equal(int64(10000), lastProgress.TotalBytes)
+		})
+
+		it("persists the metadata between stores", func() {
+			store, err := net.NewJSONMetadataStore(filepath.Join(tempDir, "metadata.json"))
+			require.NoError(err)
+			downloader.MetadataStore = store
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{fileDownload}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			assert.Equal(net.DownloadStatusUpToDate, results[0].Status)
+		})
+
+		it("downloads files that have been modified", func() {
+			handler.headers = map[string]string{"ETag": `"some-other-etag"`}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{fileDownload}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			assert.Equal(net.DownloadStatusSucceeded, results[0].Status)
+		})
+
+		it("downloads files whose local copy has changed", func() {
+			require.NoError(os.WriteFile(fileDownload.FilePath, []byte("some-contents"), 0644))
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{fileDownload}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			assert.Equal(net.DownloadStatusSucceeded, results[0].Status)
+		})
+
+		it("downloads every file when forced", func() {
+			downloader.Force = true
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{fileDownload}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			assert.Equal(net.DownloadStatusSucceeded, results[0].Status)
+		})
+	})
+
+	context("with Extract", func() {
+		var extractDir string
+
+		it.Before(func() {
+			extractDir = filepath.Join(tempDir, "extracted")
+		})
+
+		it("streams tar.gz archives into the directory", func() {
+			handler.content = newTarGz(t, map[string]string{
+				"root/bin/some-binary":     "some-binary-contents",
+				"root/lib/some-lib.so":     "some-lib-contents",
+				"root/docs/README.md":      "some-readme-contents",
+				"root/some-top-level-file": "some-top-level-contents",
+			})
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 4}
+
+			var lastProgress net.DownloadProgress
+			err := downloader.DownloadFilesWithProgressUpdates([]net.FileDownload{{
+				URL:      server.URL + "/some-archive.tar.gz",
+				FilePath: filepath.Join(tempDir, "some-archive.tar.gz"),
+				Extract: &net.Extraction{
+					Dir:             extractDir,
+					StripComponents: 1,
+					Include:         []string{"bin/*", "lib/*", "some-top-level-file"},
+					Exclude:         []string{"*.so"},
+				},
+			}}, func(progress net.DownloadProgress) { lastProgress = progress })
+			require.NoError(err)
+
+			assert.Equal(int64(len(handler.content)), lastProgress.DownloadedBytes)
+			assert.Empty(handler.ranges())
+			assert.FileExists(filepath.Join(tempDir, "some-archive.tar.gz"))
+
+			contents, err := os.ReadFile(filepath.Join(extractDir, "bin", "some-binary"))
+			require.NoError(err)
+			assert.Equal("some-binary-contents", string(contents))
+			assert.FileExists(filepath.Join(extractDir, "some-top-level-file"))
+			assert.NoFileExists(filepath.Join(extractDir, "lib", "some-lib.so"))
+			assert.NoDirExists(filepath.Join(extractDir, "docs"))
+
+			entries, err := os.ReadDir(extractDir)
+			require.NoError(err)
+			assert.Len(entries, 2)
+		})
+
+		it("extracts zip archives once they are downloaded", func() {
+			handler.content = newZip(t, map[string]string{
+				"dir/some-file":       "some-contents",
+				"dir/some-other-file": "some-other-contents",
+			})
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/download?id=123",
+				FilePath: filepath.Join(tempDir, "some-archive.zip"),
+				Extract:  &net.Extraction{Dir: extractDir},
+			}})
+			require.NoError(err)
+
+			contents, err := os.ReadFile(filepath.Join(extractDir, "dir", "some-other-file"))
+			require.NoError(err)
+			assert.Equal("some-other-contents", string(contents))
+		})
+
+		it("decompresses gzip files", func() {
+			var buffer bytes.Buffer
+			gzipWriter := gzip.NewWriter(&buffer)
+			_, err := gzipWriter.Write([]byte("some-contents"))
+			require.NoError(err)
+			require.NoError(gzipWriter.Close())
+			handler.content = buffer.Bytes()
+
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err = downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file.txt.gz",
+				FilePath: filepath.Join(tempDir, "some-file.txt.gz"),
+				Extract:  &net.Extraction{Dir: extractDir},
+			}})
+			require.NoError(err)
+
+			contents, err := os.ReadFile(filepath.Join(extractDir, "some-file.txt"))
+			require.NoError(err)
+			assert.Equal("some-contents", string(contents))
+		})
+
+		it("rejects entries that would be written outside of the directory", func() {
+			for _, name := range []string{"../evil", "/evil", "dir/../../evil"} {
+				handler.content = newZip(t, map[string]string{"some-file": "some-contents", name: "evil"})
+				downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+				err := downloader.DownloadFiles([]net.FileDownload{{
+					URL:      server.URL + "/some-archive.zip",
+					FilePath: filepath.Join(tempDir, "some-archive.zip"),
+					Extract:  &net.Extraction{Dir: extractDir},
+				}})
+
+				var entryErr *net.ArchiveEntryError
+				require.ErrorAs(err, &entryErr, name)
+				assert.Equal(name, entryErr.Name)
+				assert.NoFileExists(filepath.Join(tempDir, "evil"))
+				assert.NoFileExists(filepath.Join(extractDir, "some-file"))
+			}
+		})
+
+		it("rejects symlinks that escape the directory", func() {
+			var buffer bytes.Buffer
+			tarWriter := tar.NewWriter(&buffer)
+			require.NoError(tarWriter.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: tempDir}))
+			require.NoError(tarWriter.WriteHeader(&tar.Header{Name: "link/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}))
+			_, err := tarWriter.Write([]byte("evil"))
+			require.NoError(err)
+			require.NoError(tarWriter.Close())
+			handler.content = buffer.Bytes()
+
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err = downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-archive.tar",
+				FilePath: filepath.Join(tempDir, "some-archive.tar"),
+				Extract:  &net.Extraction{Dir: extractDir},
+			}})
+
+			var entryErr *net.ArchiveEntryError
+			require.ErrorAs(err, &entryErr)
+			assert.Equal("link", entryErr.Name)
+			assert.NoFileExists(filepath.Join(tempDir, "evil"))
+			assert.NoFileExists(filepath.Join(tempDir, "some-archive.tar"))
+		})
+
+		it("rejects symlinks that escape the directory through other symlinks", func() {
+			require.NoError(os.WriteFile(filepath.Join(tempDir, "secret"), []byte("some-secret"), 0600))
+
+			var buffer bytes.Buffer
+			tarWriter := tar.NewWriter(&buffer)
+			require.NoError(tarWriter.WriteHeader(&tar.Header{Name: "y", Typeflag: tar.TypeSymlink, Linkname: "."}))
+			require.NoError(tarWriter.WriteHeader(&tar.Header{Name: "x", Typeflag: tar.TypeSymlink, Linkname: "y/.."}))
+			require.NoError(tarWriter.Close())
+			handler.content = buffer.Bytes()
+
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-archive.tar",
+				FilePath: filepath.Join(tempDir, "some-archive.tar"),
+				Extract:  &net.Extraction{Dir: extractDir},
+			}})
+
+			var entryErr *net.ArchiveEntryError
+			require.ErrorAs(err, &entryErr)
+			assert.Equal("x", entryErr.Name)
+			assert.NoFileExists(filepath.Join(extractDir, "x", "secret"))
+		})
+	})
+
+	context("with Dir", func() {
+		var (
+			downloader net.FileDownloader
+			dir        string
+		)
+
+		it.Before(func() {
+			downloader = net.FileDownloader{Browser: net.NewBrowser()}
+			dir = filepath.Join(tempDir, "downloads")
+			require.NoError(os.Mkdir(dir, 0755))
+		})
+
+		it("names the file after the Content-Disposition header", func() {
+			handler.headers = map[string]string{
+				"Content-Disposition": `attachment; filename="fallback.txt"; filename*=UTF-8''r%C3%A9sum%C3%A9.txt`,
+			}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{
+				{URL: server.URL + "/download?id=123", Dir: dir},
+			}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+
+			filePath := filepath.Join(dir, "résumé.txt")
+			assert.Equal(filePath, results[0].FileDownload.FilePath)
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+		})
+
+		it("decodes ISO-8859-1 names and falls back to the filename parameter", func() {
+			for contentDisposition, expectedName := range map[string]string{
+				`attachment; filename*=iso-8859-1''na%EFve.txt`:                         "naïve.txt",
+				`attachment; filename*=ISO-8859-1'fr'na%EFve.txt; filename="naive.txt"`: "naïve.txt",
+				`attachment; filename*=utf-8''%FF.txt; filename="fallback.txt"`:         "fallback.txt",
+				`attachment; filename="fallback;.txt"; filename*=koi8-r''%C1.txt`:       "fallback;.txt",
+			} {
+				handler.headers = map[string]string{"Content-Disposition": contentDisposition}
+
+				results := downloader.DownloadFilesWithResults([]net.FileDownload{
+					{URL: server.URL + "/download", Dir: dir},
+				}, func(_ net.DownloadProgress) {})
+				require.NoError(results.Err())
+
+				assert.Equal(filepath.Join(dir, expectedName), results[0].FileDownload.FilePath, contentDisposition)
+				require.NoError(os.Remove(results[0].FileDownload.FilePath))
+			}
+		})
+
+		it("sanitizes the name", func() {
+			handler.headers = map[string]string{"Content-Disposition": `attachment; filename="../../some:file?.txt"`}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{
+				{URL: server.URL + "/download", Dir: dir},
+			}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+
+			assert.Equal(filepath.Join(dir, "some_file_.txt"), results[0].FileDownload.FilePath)
+			entries, err := os.ReadDir(tempDir)
+			require.NoError(err)
+			assert.Len(entries, 1)
+		})
+
+		it("names the file after the URL it was redirected to", func() {
+			handler.redirects = map[string]string{"/download": "/files/some-archive.tar.gz?token=abc"}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{
+				{URL: server.URL + "/download?id=123", Dir: dir},
+			}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+
+			assert.Equal(filepath.Join(dir, "some-archive.tar.gz"), results[0].FileDownload.FilePath)
+			assert.FileExists(filepath.Join(dir, "some-archive.tar.gz"))
+		})
+
+		it("falls back to the original URL", func() {
+			handler.redirects = map[string]string{"/some-file.txt": "/"}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{
+				{URL: server.URL + "/some-file.txt", Dir: dir},
+				{URL: server.URL + "/", Dir: dir},
+			}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+
+			assert.Equal(filepath.Join(dir, "some-file.txt"), results[0].FileDownload.FilePath)
+			assert.Equal(filepath.Join(dir, "download"), results[1].FileDownload.FilePath)
+		})
+
+		it("adds numeric suffixes to names which are taken", func() {
+			require.NoError(os.WriteFile(filepath.Join(dir, "some-archive.tar.gz"), []byte("some-contents"), 0644))
+
+			var progresses []net.DownloadProgress
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{
+				{URL: server.URL + "/some-archive.tar.gz", Dir: dir},
+				{URL: server.URL + "/some-archive.tar.gz", FilePath: filepath.Join(dir, "some-archive-1.tar.gz")},
+				{URL: server.URL + "/other/some-archive.tar.gz", Dir: dir},
+			}, func(progress net.DownloadProgress) { progresses = append(progresses, progress) })
+			require.NoError(results.Err())
+
+			assert.Equal(filepath.Join(dir, "some-archive-2.tar.gz"), results[0].FileDownload.FilePath)
+			assert.Equal(filepath.Join(dir, "some-archive-3.tar.gz"), results[2].FileDownload.FilePath)
+			for _, progress := range progresses {
+				if progress.State == net.DownloadStateDownloading || progress.State == net.DownloadStateDone {
+					assert.NotEmpty(progress.FileDownload.FilePath)
+				}
+			}
+
+			contents, err := os.ReadFile(filepath.Join(dir, "some-archive.tar.gz"))
+			require.NoError(err)
+			assert.Equal("some-contents", string(contents))
+		})
+
+		it("replaces files which exist with ReplaceExisting", func() {
+			require.NoError(os.WriteFile(filepath.Join(dir, "some-archive.tar.gz"), []byte("some-contents"), 0644))
+			downloader.ReplaceExisting = true
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{
+				{URL: server.URL + "/some-archive.tar.gz", Dir: dir},
+				{URL: server.URL + "/other/some-archive.tar.gz", Dir: dir},
+			}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+
+			assert.Equal(filepath.Join(dir, "some-archive.tar.gz"), results[0].FileDownload.FilePath)
+			assert.Equal(filepath.Join(dir, "some-archive-1.tar.gz"), results[1].FileDownload.FilePath)
+
+			contents, err := os.ReadFile(filepath.Join(dir, "some-archive.tar.gz"))
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+		})
+
+		it("reuses files which were downloaded from the same URL", func() {
+			store, err := net.NewJSONMetadataStore(filepath.Join(tempDir, "metadata.json"))
+			require.NoError(err)
+			handler.headers = map[string]string{"ETag": `"some-etag"`}
+			downloader.MetadataStore = store
+			fileDownload := net.FileDownload{URL: server.URL + "/some-file", Dir: dir}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{fileDownload}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			assert.Equal(net.DownloadStatusSucceeded, results[0].Status)
+
+			results = downloader.DownloadFilesWithResults([]net.FileDownload{fileDownload}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			assert.Equal(net.DownloadStatusUpToDate, results[0].Status)
+			assert.Equal(filepath.Join(dir, "some-file"), results[0].FileDownload.FilePath)
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(err)
+			assert.Len(entries, 1)
+		})
+	})
+
+	context("with a Sink", func() {
+		it("streams the download to a writer", func() {
+			var buffer closeRecordingBuffer
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 4}
+
+			var lastProgress net.DownloadProgress
+			err := downloader.DownloadFilesWithProgressUpdates([]net.FileDownload{{
+				URL: server.URL + "/some-file",
+				Sink: net.NewWriterSink(func() (io.Writer, error) {
+					return &buffer, nil
+				}),
+				Options: &net.FileDownloadOptions{
+					Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: sha256Hex(handler.content)}},
+				},
+			}}, func(progress net.DownloadProgress) { lastProgress = progress })
+			require.NoError(err)
+
+			assert.Equal(handler.content, buffer.Bytes())
+			assert.True(buffer.closed)
+			assert.Empty(handler.ranges())
+			assert.Equal(int64(len(handler.content)), lastProgress.TotalBytes)
+			assert.Equal(int64(len(handler.content)), lastProgress.DownloadedBytes)
+
+			entries, err := os.ReadDir(tempDir)
+			require.NoError(err)
+			assert.Empty(entries)
+		})
+
+		it("downloads segments to a writer at", func() {
+			var writerAt *memoryWriterAt
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 4}
+
+			var lastProgress net.DownloadProgress
+			err := downloader.DownloadFilesWithProgressUpdates([]net.FileDownload{{
+				URL: server.URL + "/some-file",
+				Sink: net.NewWriterAtSink(func(size int64) (io.WriterAt, error) {
+					writerAt = &memoryWriterAt{contents: make([]byte, size)}
+					return writerAt, nil
+				}),
+				Options: &net.FileDownloadOptions{
+					Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: sha256Hex(handler.content)}},
+				},
+			}}, func(progress net.DownloadProgress) { lastProgress = progress })
+			require.NoError(err)
+
+			assert.Equal(handler.content, writerAt.contents)
+			assert.Len(handler.ranges(), 4)
+			assert.Equal(int64(len(handler.content)), lastProgress.DownloadedBytes)
+		})
+
+		it("returns an error on checksum mismatch", func() {
+			var buffer closeRecordingBuffer
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL: server.URL + "/some-file",
+				Sink: net.NewWriterSink(func() (io.Writer, error) {
+					return &buffer, nil
+				}),
+				Options: &net.FileDownloadOptions{
+					Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: "bad-digest"}},
+				},
+			}})
+
+			var mismatchErr *net.ChecksumMismatchError
+			require.ErrorAs(err, &mismatchErr)
+			assert.Equal("some-file", mismatchErr.FilePath)
+			assert.True(buffer.closed)
+		})
+
+		it("returns an error when the sink cannot be opened", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL: server.URL + "/some-file",
+				Sink: net.NewWriterSink(func() (io.Writer, error) {
+					return nil, errors.New("some-error")
+				}),
+			}})
+			require.EqualError(err, "failed to download some-file: failed to open sink: some-error")
+		})
+	})
+
+	context("with Mirrors", func() {
+		var (
+			mirrorHandler *fileServerHandler
+			mirror        *httptest.Server
+			filePath      string
+		)
+
+		it.Before(func() {
+			mirrorHandler = &fileServerHandler{content: handler.content}
+			mirror = httptest.NewServer(mirrorHandler)
+			filePath = filepath.Join(tempDir, "some-file")
+		})
+
+		it.After(func() {
+			mirror.Close()
+		})
+
+		it("fails over to the next mirror", func() {
+			handler.statusCodes = map[string]int{"/some-file": http.StatusNotFound}
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			var progressURLs []string
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Mirrors: []string{mirror.URL + "/some-file"},
+				},
+			}}, func(progress net.DownloadProgress) {
+				if progress.State == net.DownloadStateDownloading {
+					progressURLs = append(progressURLs, progress.URL)
+				}
+			})
+			require.NoError(results.Err())
+
+			assert.Equal(mirror.URL+"/some-file", results[0].URL)
+			assert.NotEmpty(progressURLs)
+			for _, url := range progressURLs {
+				assert.Equal(mirror.URL+"/some-file", url)
+			}
+
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+		})
+
+		it("resumes from the last byte received", func() {
+			handler.truncateAt = 4000
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			var lastProgress net.DownloadProgress
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Mirrors:   []string{mirror.URL + "/some-file"},
+					Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: sha256Hex(handler.content)}},
+				},
+			}}, func(progress net.DownloadProgress) { lastProgress = progress })
+			require.NoError(results.Err())
+
+			assert.Equal([]string{"bytes=4000-"}, mirrorHandler.ranges())
+			assert.Equal(mirror.URL+"/some-file", results[0].URL)
+			assert.Equal(2, results[0].Attempts)
+			assert.Equal(int64(10000), results[0].Bytes)
+			assert.Equal(int64(10000), lastProgress.DownloadedBytes)
+			assert.Equal(mirror.URL+"/some-file", lastProgress.URL)
+
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+		})
+
+		it("starts over when the mirror does not accept ranges", func() {
+			handler.truncateAt = 4000
+			mirrorHandler.disableRanges = true
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Mirrors: []string{mirror.URL + "/some-file"},
+				},
+			}}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			assert.Equal(int64(10000), results[0].Bytes)
+
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+		})
+
+		it("opens a new writer from a Sink when the mirror does not accept ranges", func() {
+			handler.truncateAt = 4000
+			mirrorHandler.disableRanges = true
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			var buffers []*closeRecordingBuffer
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{{
+				URL: server.URL + "/some-file",
+				Sink: net.NewWriterSink(func() (io.Writer, error) {
+					buffer := &closeRecordingBuffer{}
+					buffers = append(buffers, buffer)
+					return buffer, nil
+				}),
+				Options: &net.FileDownloadOptions{
+					Mirrors: []string{mirror.URL + "/some-file"},
+				},
+			}}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+
+			require.Len(buffers, 2)
+			assert.Equal(handler.content[:4000], buffers[0].Bytes())
+			assert.True(buffers[0].closed)
+			assert.Equal(handler.content, buffers[1].Bytes())
+			assert.True(buffers[1].closed)
+		})
+
+		it("resumes failed segments from the next mirror", func() {
+			handler.failRanges = map[string]int{"bytes=5000-9999": 1}
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 2}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Mirrors: []string{mirror.URL + "/some-file"},
+				},
+			}})
+			require.NoError(err)
+
+			assert.ElementsMatch([]string{"bytes=0-4999", "bytes=5000-9999"}, handler.ranges())
+			assert.Equal([]string{"bytes=5000-9999"}, mirrorHandler.ranges())
+
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+		})
+
+		it("downloads the file again from the next mirror on checksum mismatch", func() {
+			handler.content = bytes.Repeat([]byte("9876543210"), 1000)
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 2}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Mirrors:   []string{mirror.URL + "/some-file"},
+					Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: sha256Hex(mirrorHandler.content)}},
+				},
+			}}, func(_ net.DownloadProgress) {})
+			require.NoError(results.Err())
+			assert.Equal(mirror.URL+"/some-file", results[0].URL)
+			assert.Equal(int64(10000), results[0].Bytes)
+
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(mirrorHandler.content, contents)
+		})
+
+		it("returns the error from every mirror when they all fail", func() {
+			handler.truncateAt = 4000
+			mirrorHandler.statusCodes = map[string]int{"/some-file": http.StatusNotFound}
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			results := downloader.DownloadFilesWithResults([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Mirrors: []string{mirror.URL + "/some-file"},
+				},
+			}}, func(_ net.DownloadProgress) {})
+
+			assert.Equal(net.DownloadStatusFailed, results[0].Status)
+			assert.Equal(mirror.URL+"/some-file", results[0].URL)
+			assert.ErrorIs(results[0].Err, io.ErrUnexpectedEOF)
+			assert.Contains(results[0].Err.Error(), "all mirrors failed")
+			assert.Contains(results[0].Err.Error(), server.URL+"/some-file: ")
+			assert.Contains(results[0].Err.Error(), mirror.URL+"/some-file: expected 206 response to range request, got: 404 Not Found")
+			assert.NoFileExists(filePath)
+		})
+	})
+
+	context("with Segments", func() {
+		it("downloads byte ranges in parallel and sums their progress", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 4}
+			filePath := filepath.Join(tempDir, "some-file")
+
+			var lastProgress net.DownloadProgress
+			err := downloader.DownloadFilesWithProgressUpdates(
+				[]net.FileDownload{{URL: server.URL + "/some-file", FilePath: filePath}},
+				func(progress net.DownloadProgress) { lastProgress = progress },
+			)
+			require.NoError(err)
+
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+			assert.ElementsMatch(
+				[]string{"bytes=0-2499", "bytes=2500-4999", "bytes=5000-7499", "bytes=7500-9999"},
+				handler.ranges(),
+			)
+			assert.Equal(int64(10000), lastProgress.TotalBytes)
+			assert.Equal(int64(10000), lastProgress.DownloadedBytes)
+		})
+
+		it("retries failed segments on their own", func() {
+			handler.failRanges = map[string]int{"bytes=5000-9999": 2}
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 2, SegmentRetries: 2}
+			filePath := filepath.Join(tempDir, "some-file")
+
+			err := downloader.DownloadFiles([]net.FileDownload{{URL: server.URL + "/some-file", FilePath: filePath}})
+			require.NoError(err)
+
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+			assert.ElementsMatch(
+				[]string{"bytes=0-4999", "bytes=5000-9999", "bytes=5000-9999", "bytes=5000-9999"},
+				handler.ranges(),
+			)
+		})
+
+		it("fails when a segment runs out of retries", func() {
+			handler.failRanges = map[string]int{"bytes=5000-9999": 2}
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 2, SegmentRetries: 1}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{URL: server.URL + "/some-file", FilePath: filepath.Join(tempDir, "some-file")}})
+			assert.EqualError(err, "failed to download some-file: failed to download segment 2: expected 206 response to range request, got: 500 Internal Server Error")
+		})
+
+		it("downloads in a single stream when the server does not accept ranges", func() {
+			handler.disableRanges = true
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 4}
+			filePath := filepath.Join(tempDir, "some-file")
+
+			err := downloader.DownloadFiles([]net.FileDownload{{URL: server.URL + "/some-file", FilePath: filePath}})
+			require.NoError(err)
+
+			contents, err := os.ReadFile(filePath)
+			require.NoError(err)
+			assert.Equal(handler.content, contents)
+			assert.Empty(handler.ranges())
+		})
+	})
+
+	context("with Checksums", func() {
+		var (
+			sha256Digest string
+			filePath     string
+		)
+
+		it.Before(func() {
+			sum := sha256.Sum256(handler.content)
+			sha256Digest = hex.EncodeToString(sum[:])
+			filePath = filepath.Join(tempDir, "some-file")
+		})
+
+		it("verifies the downloaded file", func() {
+			md5Sum := md5.Sum(handler.content)
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Checksums: []net.Checksum{
+						{Algorithm: net.SHA256, Digest: strings.ToUpper(sha256Digest)},
+						{Algorithm: net.MD5, Digest: hex.EncodeToString(md5Sum[:])},
+					},
+				},
+			}})
+			require.NoError(err)
+			assert.FileExists(filePath)
+		})
+
+		it("verifies segmented downloads once they are assembled", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), Segments: 3}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: "bad-digest"}},
+				},
+			}})
+
+			var mismatchErr *net.ChecksumMismatchError
+			require.ErrorAs(err, &mismatchErr)
+			assert.Equal(sha256Digest, mismatchErr.Actual)
+			assert.NoFileExists(filePath)
+		})
+
+		it("deletes the file and returns a typed error on mismatch", func() {
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: "bad-digest"}},
+				},
+			}})
+
+			var mismatchErr *net.ChecksumMismatchError
+			require.ErrorAs(err, &mismatchErr)
+			assert.Equal(net.SHA256, mismatchErr.Algorithm)
+			assert.Equal("bad-digest", mismatchErr.Expected)
+			assert.Equal(sha256Digest, mismatchErr.Actual)
+			assert.NoFileExists(filePath)
+		})
+
+		it("moves the file to the quarantine directory on mismatch", func() {
+			quarantineDir := filepath.Join(tempDir, "quarantine")
+			downloader := net.FileDownloader{Browser: net.NewBrowser(), QuarantineDir: quarantineDir}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					Checksums: []net.Checksum{{Algorithm: net.SHA1, Digest: "bad-digest"}},
+				},
+			}})
+
+			var mismatchErr *net.ChecksumMismatchError
+			require.ErrorAs(err, &mismatchErr)
+			assert.NoFileExists(filePath)
+			assert.FileExists(filepath.Join(quarantineDir, "some-file"))
+		})
+
+		it("fetches the expected digest from a sidecar file", func() {
+			handler.otherFiles = map[string][]byte{
+				"/some-file.sha256": []byte("0000  other-file\n" + sha256Digest + " *some-file\n"),
+			}
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					FetchChecksumFile: true,
+				},
+			}})
+			require.NoError(err)
+
+			handler.otherFiles = map[string][]byte{"/some-file.sha256": []byte("bad-digest")}
+
+			err = downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					FetchChecksumFile: true,
+				},
+			}})
+			var mismatchErr *net.ChecksumMismatchError
+			assert.ErrorAs(err, &mismatchErr)
+		})
+
+		it("uses the digests from the Digest and Content-Digest headers", func() {
+			sha512Sum := sha512.Sum512(handler.content)
+			handler.headers = map[string]string{
+				"Content-Digest": "sha-512=:" + base64.StdEncoding.EncodeToString(sha512Sum[:]) + ":",
+			}
+			downloader := net.FileDownloader{Browser: net.NewBrowser()}
+
+			err := downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					UseDigestHeaders: true,
+				},
+			}})
+			require.NoError(err)
+
+			handler.headers = map[string]string{"Digest": "SHA-256=" + base64.StdEncoding.EncodeToString([]byte("bad-digest"))}
+
+			err = downloader.DownloadFiles([]net.FileDownload{{
+				URL:      server.URL + "/some-file",
+				FilePath: filePath,
+				Options: &net.FileDownloadOptions{
+					UseDigestHeaders: true,
+				},
+			}})
+			var mismatchErr *net.ChecksumMismatchError
+			assert.ErrorAs(err, &mismatchErr)
+		})
+	})
+}
+
+type fileServerHandler struct {
+	content       []byte
+	disableRanges bool
+	failRanges    map[string]int
+	headers       map[string]string
+	otherFiles    map[string][]byte
+	statusCodes   map[string]int
+	redirects     map[string]string
+	// requiredHeaders are checked on every request, which is forbidden if
+	// they do not match.
+	requiredHeaders map[string]string
+	// truncateAt cuts off responses for the whole file after that many bytes.
+	truncateAt int
+	// unknownLength streams the file without a Content-Length header.
+	unknownLength bool
+	// getDelay slows down GET requests, so that concurrent ones overlap.
+	getDelay time.Duration
+	// stallAt stops responses for the whole file after that many bytes, until
+	// the request is cancelled.
+	stallAt int
+
+	mu            sync.Mutex
+	requestRanges []string
+	activeGets    int
+	maxActiveGets int
+}
+
+func (h *fileServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		h.mu.Lock()
+		h.activeGets++
+		if h.activeGets > h.maxActiveGets {
+			h.maxActiveGets = h.activeGets
+		}
+		h.mu.Unlock()
+
+		defer func() {
+			h.mu.Lock()
+			h.activeGets--
+			h.mu.Unlock()
+		}()
+
+		time.Sleep(h.getDelay)
+	}
+
+	for name, value := range h.requiredHeaders {
+		if r.Header.Get(name) != value {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	if location, ok := h.redirects[r.URL.Path]; ok {
+		http.Redirect(w, r, location, http.StatusFound)
+		return
+	}
+
+	if statusCode, ok := h.statusCodes[r.URL.Path]; ok {
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	if content, ok := h.otherFiles[r.URL.Path]; ok {
+		_, _ = w.Write(content)
+		return
+	}
+
+	for name, value := range h.headers {
+		w.Header().Set(name, value)
+	}
+
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		h.mu.Lock()
+		h.requestRanges = append(h.requestRanges, rangeHeader)
+		shouldFail := h.failRanges[rangeHeader] > 0
+		if shouldFail {
+			h.failRanges[rangeHeader]--
+		}
+		h.mu.Unlock()
+
+		if shouldFail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if h.truncateAt > 0 && r.Header.Get("Range") == "" && r.Method == http.MethodGet {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(h.content)))
+		_, _ = w.Write(h.content[:h.truncateAt])
+		return
+	}
+
+	if h.stallAt > 0 && r.Header.Get("Range") == "" && r.Method == http.MethodGet {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(h.content)))
+		_, _ = w.Write(h.content[:h.stallAt])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+		return
+	}
+
+	if h.unknownLength {
+		for offset := 0; offset < len(h.content); offset += 1000 {
+			_, _ = w.Write(h.content[offset : offset+1000])
+			w.(http.Flusher).Flush()
+		}
+		return
+	}
+
+	if h.disableRanges {
+		r.Header.Del("Range")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(h.content)
+		return
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(h.content))
+}
+
+func sha256Hex(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+type closeRecordingBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeRecordingBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+type memoryWriterAt struct {
+	mu       sync.Mutex
+	contents []byte
+}
+
+func (w *memoryWriterAt) WriteAt(bytes []byte, offset int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return copy(w.contents[offset:], bytes), nil
+}
+
+func (w *memoryWriterAt) ReadAt(bytes []byte, offset int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if offset >= int64(len(w.contents)) {
+		return 0, io.EOF
+	}
+	n := copy(bytes, w.contents[offset:])
+	if n < len(bytes) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func newTarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, name := range sortedKeys(files) {
+		requirepkg.NoError(t, tarWriter.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(files[name])),
+		}))
+		_, err := tarWriter.Write([]byte(files[name]))
+		requirepkg.NoError(t, err)
+	}
+
+	requirepkg.NoError(t, tarWriter.Close())
+	requirepkg.NoError(t, gzipWriter.Close())
+
+	return buffer.Bytes()
+}
+
+func newZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	zipWriter := zip.NewWriter(&buffer)
+
+	for _, name := range sortedKeys(files) {
+		writer, err := zipWriter.Create(name)
+		requirepkg.NoError(t, err)
+		_, err = writer.Write([]byte(files[name]))
+		requirepkg.NoError(t, err)
+	}
+
+	requirepkg.NoError(t, zipWriter.Close())
+
+	return buffer.Bytes()
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (h *fileServerHandler) ranges() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string{}, h.requestRanges...)
+}
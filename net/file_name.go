@@ -0,0 +1,266 @@
+package net
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultFileName is used when neither the response nor the URL of a download
+// name the file.
+const defaultFileName = "download"
+
+// maxFileNameLength is the longest file name, in bytes, that most filesystems
+// support.
+const maxFileNameLength = 255
+
+// fileNameFromResponse returns the name of the file served in a response,
+// from its Content-Disposition header or else the path of the URL it was
+// served from after any redirects. It returns an empty string if neither
+// names the file.
+func fileNameFromResponse(resp *http.Response) string {
+	if name := fileNameFromContentDisposition(resp.Header.Get("Content-Disposition")); name != "" {
+		return name
+	}
+
+	if resp.Request != nil && resp.Request.URL != nil {
+		return fileNameFromURLPath(resp.Request.URL.Path)
+	}
+
+	return ""
+}
+
+// fileNameFromContentDisposition returns the sanitized filename parameter of a
+// Content-Disposition header. The RFC 5987 filename* parameter is preferred
+// when it is present and can be decoded.
+func fileNameFromContentDisposition(contentDisposition string) string {
+	if contentDisposition == "" {
+		return ""
+	}
+
+	_, params, err := mime.ParseMediaType(contentDisposition)
+	if err != nil {
+		return ""
+	}
+
+	// The mime package only decodes UTF-8 and US-ASCII filename* parameters,
+	// and returns their undecoded value if it is not valid, so they are
+	// decoded here, falling back to the filename parameter.
+	raw := contentDispositionParams(contentDisposition)
+	if extValue, ok := raw["filename*"]; ok {
+		if name, ok := decodeExtValue(extValue); ok {
+			return sanitizeFileName(name)
+		}
+		return sanitizeFileName(raw["filename"])
+	}
+
+	return sanitizeFileName(params["filename"])
+}
+
+// contentDispositionParams returns the parameters of a Content-Disposition
+// header without decoding them, keyed by their lowercase names. Quoted values
+// are unquoted.
+func contentDispositionParams(contentDisposition string) map[string]string {
+	params := map[string]string{}
+
+	_, rest, _ := strings.Cut(contentDisposition, ";")
+	for {
+		rest = strings.TrimLeft(rest, " \t;")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			return params
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimLeft(value, " \t")
+
+		if strings.HasPrefix(value, `"`) {
+			var unquoted strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				unquoted.WriteByte(value[i])
+			}
+			if i < len(value) {
+				i++
+			}
+			value, rest = unquoted.String(), value[i:]
+		} else {
+			value, rest, _ = strings.Cut(value, ";")
+			value = strings.TrimSpace(value)
+		}
+
+		if _, ok := params[key]; !ok {
+			params[key] = value
+		}
+	}
+}
+
+// decodeExtValue decodes an RFC 5987 ext-value, like
+// "UTF-8'en'na%C3%AFve.txt", in the UTF-8, ISO-8859-1 or US-ASCII charsets. It
+// reports false if the value is in another charset or is not valid.
+func decodeExtValue(extValue string) (string, bool) {
+	charset, rest, ok := strings.Cut(extValue, "'")
+	if !ok {
+		return "", false
+	}
+	_, encoded, ok := strings.Cut(rest, "'")
+	if !ok {
+		return "", false
+	}
+
+	decoded, err := url.PathUnescape(encoded)
+	if err != nil || decoded == "" {
+		return "", false
+	}
+
+	switch strings.ToLower(charset) {
+	case "utf-8":
+		return decoded, utf8.ValidString(decoded)
+	case "us-ascii":
+		for i := 0; i < len(decoded); i++ {
+			if decoded[i] >= utf8.RuneSelf {
+				return "", false
+			}
+		}
+		return decoded, true
+	case "iso-8859-1":
+		// Each byte is the code point of the same value.
+		runes := make([]rune, len(decoded))
+		for i := 0; i < len(decoded); i++ {
+			runes[i] = rune(decoded[i])
+		}
+		return string(runes), true
+	default:
+		return "", false
+	}
+}
+
+func fileNameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return fileNameFromURLPath(u.Path)
+}
+
+func fileNameFromURLPath(urlPath string) string {
+	if strings.HasSuffix(urlPath, "/") {
+		return ""
+	}
+	return sanitizeFileName(path.Base(urlPath))
+}
+
+// sanitizeFileName strips any directories from name and replaces characters
+// which are not allowed in file names on common filesystems. It returns an
+// empty string if nothing usable is left.
+func sanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Windows does not allow file names to end with a space or dot, and
+	// leading ones make for hidden or awkward files elsewhere.
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return ""
+	}
+
+	if isReservedFileName(name) {
+		name = "_" + name
+	}
+
+	if len(name) > maxFileNameLength {
+		base, ext := splitFileExtension(name)
+		if len(ext) >= maxFileNameLength {
+			ext = ""
+		}
+		base = truncateUTF8(base, maxFileNameLength-len(ext))
+		name = base + ext
+	}
+
+	return name
+}
+
+// isReservedFileName reports whether name is a device name on Windows, which
+// cannot be used as a file name whatever its extension.
+func isReservedFileName(name string) bool {
+	base := strings.ToUpper(name)
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '1' && base[3] <= '9'
+	}
+
+	return false
+}
+
+// splitFileExtension splits name into its base and extension, treating
+// compressed tarballs like "file.tar.gz" as having a single extension.
+func splitFileExtension(name string) (string, string) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if ext == "" || base == "" {
+		return name, ""
+	}
+
+	if tarExt := filepath.Ext(base); strings.EqualFold(tarExt, ".tar") && tarExt != base {
+		return strings.TrimSuffix(base, tarExt), tarExt + ext
+	}
+
+	return base, ext
+}
+
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+
+	s = s[:maxBytes]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
+
+// uniqueFilePath returns filePath, or if it is taken, filePath with the lowest
+// numeric suffix that is not, like "file-1.tar.gz".
+func uniqueFilePath(filePath string, taken func(string) bool) string {
+	if !taken(filePath) {
+		return filePath
+	}
+
+	dir := filepath.Dir(filePath)
+	base, ext := splitFileExtension(filepath.Base(filePath))
+
+	for i := 1; ; i++ {
+		suffix := fmt.Sprintf("-%d", i)
+		candidate := filepath.Join(dir, truncateUTF8(base, maxFileNameLength-len(suffix)-len(ext))+suffix+ext)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
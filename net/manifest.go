@@ -0,0 +1,434 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestFormat is the format of a file listing downloads.
+type ManifestFormat string
+
+const (
+	// ManifestFormatJSON is an array of objects with the fields of
+	// ManifestEntry.
+	ManifestFormatJSON ManifestFormat = "json"
+
+	// ManifestFormatCSV has a header row naming its columns after the JSON
+	// fields of ManifestEntry. Mirrors are separated by spaces, and headers
+	// are written as "Name: value" and separated by semicolons.
+	ManifestFormatCSV ManifestFormat = "csv"
+
+	// ManifestFormatURLList has a URL on each line. Blank lines and lines
+	// starting with "#" are ignored.
+	ManifestFormatURLList ManifestFormat = "urls"
+)
+
+// ManifestEntry is a download listed in a manifest. Entries with neither a
+// Path nor a Dir are saved to the current directory.
+type ManifestEntry struct {
+	URL string `json:"url"`
+	// Path is the file the download is saved to.
+	Path string `json:"path"`
+	// Dir is the directory the download is saved to, named after the
+	// response.
+	Dir string `json:"dir"`
+	// Checksum is written as "<algorithm>:<hex digest>".
+	Checksum string            `json:"checksum"`
+	Mirrors  []string          `json:"mirrors"`
+	Headers  map[string]string `json:"headers"`
+}
+
+// ManifestError is a problem with a manifest, at the line it was found on.
+type ManifestError struct {
+	Line int
+	// Field is the field of the entry on Line with the problem, if the
+	// problem is with a single field.
+	Field string
+	Err   error
+}
+
+func (e *ManifestError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Err)
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// ManifestErrors are all of the problems found in a manifest.
+type ManifestErrors []*ManifestError
+
+func (e ManifestErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// ReadManifest reads the downloads listed in the manifest at path. Its format
+// is determined by its extension: ".json" for JSON, ".csv" for CSV, or a list
+// of URLs otherwise.
+func ReadManifest(path string) ([]FileDownload, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer file.Close()
+
+	fileDownloads, err := ParseManifest(file, manifestFormatFromPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s:\n%w", path, err)
+	}
+
+	return fileDownloads, nil
+}
+
+func manifestFormatFromPath(path string) ManifestFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ManifestFormatJSON
+	case ".csv":
+		return ManifestFormatCSV
+	default:
+		return ManifestFormatURLList
+	}
+}
+
+// ParseManifest parses the downloads listed in a manifest. If any entries are
+// invalid, the error is a ManifestErrors with a ManifestError for each
+// problem.
+func ParseManifest(reader io.Reader, format ManifestFormat) ([]FileDownload, error) {
+	var parser func(io.Reader) ([]lineEntry, ManifestErrors)
+	switch format {
+	case ManifestFormatJSON:
+		parser = parseJSONManifest
+	case ManifestFormatCSV:
+		parser = parseCSVManifest
+	case ManifestFormatURLList:
+		parser = parseURLListManifest
+	default:
+		return nil, fmt.Errorf("unsupported manifest format: %q", format)
+	}
+
+	entries, errs := parser(reader)
+
+	var fileDownloads []FileDownload
+	for _, entry := range entries {
+		fileDownload, entryErrs := entry.fileDownload()
+		errs = append(errs, entryErrs...)
+		fileDownloads = append(fileDownloads, fileDownload)
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].Line < errs[j].Line
+		})
+		return nil, errs
+	}
+
+	return fileDownloads, nil
+}
+
+// lineEntry is a ManifestEntry and the line it starts on.
+type lineEntry struct {
+	ManifestEntry
+	line int
+}
+
+func (e lineEntry) fileDownload() (FileDownload, ManifestErrors) {
+	var errs ManifestErrors
+	addErr := func(field string, err error) {
+		errs = append(errs, &ManifestError{Line: e.line, Field: field, Err: err})
+	}
+
+	fileDownload := FileDownload{
+		URL:      e.URL,
+		FilePath: e.Path,
+		Dir:      e.Dir,
+	}
+	options := FileDownloadOptions{
+		Mirrors: e.Mirrors,
+		Headers: e.Headers,
+	}
+
+	if e.URL == "" {
+		addErr("url", errors.New("is required"))
+	} else if err := validateDownloadURL(e.URL); err != nil {
+		addErr("url", err)
+	}
+
+	for i, mirror := range e.Mirrors {
+		if err := validateDownloadURL(mirror); err != nil {
+			addErr(fmt.Sprintf("mirrors[%d]", i), err)
+		}
+	}
+
+	if e.Path != "" && e.Dir != "" {
+		addErr("dir", errors.New("cannot be set with path"))
+	}
+	if e.Path == "" && e.Dir == "" {
+		fileDownload.Dir = "."
+	}
+
+	if e.Checksum != "" {
+		checksum, err := ParseChecksum(e.Checksum)
+		if err != nil {
+			addErr("checksum", err)
+		}
+		options.Checksums = []Checksum{checksum}
+	}
+
+	var headerNames []string
+	for name := range e.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	for _, name := range headerNames {
+		if err := validateHeader(name, e.Headers[name]); err != nil {
+			addErr(fmt.Sprintf("headers[%q]", name), err)
+		}
+	}
+
+	if len(options.Mirrors) > 0 || len(options.Headers) > 0 || len(options.Checksums) > 0 {
+		fileDownload.Options = &options
+	}
+
+	return fileDownload, errs
+}
+
+func validateDownloadURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %q", rawURL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("expected an http or https URL, got %q", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in URL %q", rawURL)
+	}
+	return nil
+}
+
+func validateHeader(name, value string) error {
+	if name == "" {
+		return errors.New("name is empty")
+	}
+	for _, r := range name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return fmt.Errorf("invalid character %q in name", r)
+		}
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("value contains a line break")
+	}
+	return nil
+}
+
+func parseJSONManifest(reader io.Reader) ([]lineEntry, ManifestErrors) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, ManifestErrors{{Line: 1, Err: err}}
+	}
+
+	syntaxErr := func(err error) ManifestErrors {
+		var jsonSyntaxErr *json.SyntaxError
+		if errors.As(err, &jsonSyntaxErr) {
+			return ManifestErrors{{Line: lineAt(data, jsonSyntaxErr.Offset), Err: err}}
+		}
+		return ManifestErrors{{Line: lineAt(data, int64(len(data))), Err: err}}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, syntaxErr(err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, ManifestErrors{{Line: lineAt(data, decoder.InputOffset()), Err: errors.New("expected an array of entries")}}
+	}
+
+	var (
+		entries []lineEntry
+		errs    ManifestErrors
+	)
+	for decoder.More() {
+		offset := entryOffset(data, decoder.InputOffset())
+
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, append(errs, syntaxErr(err)...)
+		}
+
+		entry := lineEntry{line: lineAt(data, offset)}
+
+		entryDecoder := json.NewDecoder(bytes.NewReader(raw))
+		entryDecoder.DisallowUnknownFields()
+		if err := entryDecoder.Decode(&entry.ManifestEntry); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				errs = append(errs, &ManifestError{
+					Line:  lineAt(data, offset+typeErr.Offset),
+					Field: typeErr.Field,
+					Err:   fmt.Errorf("expected %s, got %s", typeErr.Type, typeErr.Value),
+				})
+			} else {
+				errs = append(errs, &ManifestError{Line: entry.line, Err: err})
+			}
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, append(errs, syntaxErr(err)...)
+	}
+
+	return entries, errs
+}
+
+// entryOffset skips the whitespace and comma before the JSON value at offset.
+func entryOffset(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+		offset++
+	}
+	return offset
+}
+
+// lineAt returns the line number of the byte at offset.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func parseCSVManifest(reader io.Reader) ([]lineEntry, ManifestErrors) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, ManifestErrors{csvError(err)}
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "url", "path", "dir", "checksum", "mirrors", "headers":
+		default:
+			return nil, ManifestErrors{{Line: 1, Field: name, Err: errors.New("unknown column")}}
+		}
+		if _, ok := columns[name]; ok {
+			return nil, ManifestErrors{{Line: 1, Field: name, Err: errors.New("duplicate column")}}
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, ManifestErrors{{Line: 1, Field: "url", Err: errors.New("missing column")}}
+	}
+
+	var (
+		entries []lineEntry
+		errs    ManifestErrors
+	)
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, append(errs, csvError(err))
+		}
+
+		line, _ := csvReader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		entry := lineEntry{
+			ManifestEntry: ManifestEntry{
+				URL:      field("url"),
+				Path:     field("path"),
+				Dir:      field("dir"),
+				Checksum: field("checksum"),
+			},
+			line: line,
+		}
+
+		if mirrors := strings.Fields(field("mirrors")); len(mirrors) > 0 {
+			entry.Mirrors = mirrors
+		}
+
+		if headers := field("headers"); headers != "" {
+			entry.Headers = map[string]string{}
+			for _, header := range strings.Split(headers, ";") {
+				name, value, found := strings.Cut(header, ":")
+				if !found {
+					errs = append(errs, &ManifestError{Line: line, Field: "headers", Err: fmt.Errorf("expected Name: value, got %q", strings.TrimSpace(header))})
+					continue
+				}
+				entry.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, errs
+}
+
+func csvError(err error) *ManifestError {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &ManifestError{Line: parseErr.Line, Err: parseErr.Err}
+	}
+	return &ManifestError{Err: err}
+}
+
+func parseURLListManifest(reader io.Reader) ([]lineEntry, ManifestErrors) {
+	var (
+		entries []lineEntry
+		line    int
+	)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		entries = append(entries, lineEntry{ManifestEntry: ManifestEntry{URL: text}, line: line})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, ManifestErrors{{Line: line + 1, Err: err}}
+	}
+
+	return entries, nil
+}
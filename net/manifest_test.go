@@ -0,0 +1,203 @@
+package net_test
+
+import (
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	spec.Run(t, "Manifest", testManifest, spec.Report(report.Terminal{}))
+}
+
+func testManifest(t *testing.T, context spec.G, it spec.S) {
+	var (
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	const sha256Digest = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	context("ParseManifest", func() {
+		context("JSON", func() {
+			it("parses fully specified downloads", func() {
+				fileDownloads, err := net.ParseManifest(strings.NewReader(`[
+  {
+    "url": "https://example.com/some-file",
+    "path": "some-dir/some-file",
+    "checksum": "SHA256:`+strings.ToUpper(sha256Digest)+`",
+    "mirrors": ["https://mirror.example.com/some-file"],
+    "headers": {"Authorization": "Bearer some-token"}
+  },
+  {"url": "https://example.com/some-other-file", "dir": "some-dir"},
+  {"url": "https://example.com/download?id=123"}
+]`), net.ManifestFormatJSON)
+				require.NoError(err)
+
+				assert.Equal([]net.FileDownload{
+					{
+						URL:      "https://example.com/some-file",
+						FilePath: "some-dir/some-file",
+						Options: &net.FileDownloadOptions{
+							Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: sha256Digest}},
+							Mirrors:   []string{"https://mirror.example.com/some-file"},
+							Headers:   map[string]string{"Authorization": "Bearer some-token"},
+						},
+					},
+					{URL: "https://example.com/some-other-file", Dir: "some-dir"},
+					{URL: "https://example.com/download?id=123", Dir: "."},
+				}, fileDownloads)
+			})
+
+			it("returns an error for each invalid field with its line", func() {
+				_, err := net.ParseManifest(strings.NewReader(`[
+  {"url": "https://example.com/some-file"},
+  {
+    "path": "some-file",
+    "dir": "some-dir",
+    "checksum": "sha256:abc"
+  },
+  {
+    "url": "ftp://example.com/some-file",
+    "mirrors": ["https://mirror.example.com/some-file", "/some-file"],
+    "headers": {"Bad Name": "some-value"}
+  },
+  {
+    "url": "https://example.com/some-file",
+    "mirrors": "https://mirror.example.com/some-file"
+  },
+  {"url": "https://example.com/some-file", "some-field": "some-value"}
+]`), net.ManifestFormatJSON)
+
+				var manifestErrs net.ManifestErrors
+				require.ErrorAs(err, &manifestErrs)
+				assert.EqualError(err, strings.Join([]string{
+					`line 3: url: is required`,
+					`line 3: dir: cannot be set with path`,
+					`line 3: checksum: invalid sha256 digest: "abc"`,
+					`line 8: url: expected an http or https URL, got "ftp://example.com/some-file"`,
+					`line 8: mirrors[1]: expected an http or https URL, got "/some-file"`,
+					`line 8: headers["Bad Name"]: invalid character ' ' in name`,
+					`line 15: mirrors: expected []string, got string`,
+					`line 17: json: unknown field "some-field"`,
+				}, "\n"))
+
+				assert.Equal(8, manifestErrs[3].Line)
+				assert.Equal("url", manifestErrs[3].Field)
+			})
+
+			it("returns the line of syntax errors", func() {
+				_, err := net.ParseManifest(strings.NewReader("[\n  {\"url\": \"https://example.com/some-file\"},\n  {\"url\" \"https://example.com/some-file\"}\n]"), net.ManifestFormatJSON)
+				require.Error(err)
+				assert.Contains(err.Error(), "line 3: invalid character")
+
+				_, err = net.ParseManifest(strings.NewReader(`{"url": "https://example.com/some-file"}`), net.ManifestFormatJSON)
+				assert.EqualError(err, "line 1: expected an array of entries")
+			})
+		})
+
+		context("CSV", func() {
+			it("parses downloads with a header row", func() {
+				fileDownloads, err := net.ParseManifest(strings.NewReader(strings.Join([]string{
+					"url,path,checksum,mirrors,headers",
+					`https://example.com/some-file,some-file,sha256:` + sha256Digest + `,https://a.example.com/some-file https://b.example.com/some-file,"Authorization: Bearer some-token; Accept: */*"`,
+					"https://example.com/some-other-file,,,,",
+				}, "\n")), net.ManifestFormatCSV)
+				require.NoError(err)
+
+				assert.Equal([]net.FileDownload{
+					{
+						URL:      "https://example.com/some-file",
+						FilePath: "some-file",
+						Options: &net.FileDownloadOptions{
+							Checksums: []net.Checksum{{Algorithm: net.SHA256, Digest: sha256Digest}},
+							Mirrors:   []string{"https://a.example.com/some-file", "https://b.example.com/some-file"},
+							Headers:   map[string]string{"Authorization": "Bearer some-token", "Accept": "*/*"},
+						},
+					},
+					{URL: "https://example.com/some-other-file", Dir: "."},
+				}, fileDownloads)
+			})
+
+			it("returns an error for each invalid field with its line", func() {
+				_, err := net.ParseManifest(strings.NewReader(strings.Join([]string{
+					"url,checksum,headers",
+					"https://example.com/some-file,md5:abc,",
+					`"https://example.com/some-file`,
+					`with-a-line-break",,some-header`,
+				}, "\n")), net.ManifestFormatCSV)
+				assert.EqualError(err, strings.Join([]string{
+					`line 2: checksum: invalid md5 digest: "abc"`,
+					`line 3: headers: expected Name: value, got "some-header"`,
+					`line 3: url: invalid URL: "https://example.com/some-file\nwith-a-line-break"`,
+				}, "\n"))
+			})
+
+			it("validates the header row", func() {
+				_, err := net.ParseManifest(strings.NewReader("path,checksum\nsome-file,\n"), net.ManifestFormatCSV)
+				assert.EqualError(err, "line 1: url: missing column")
+
+				_, err = net.ParseManifest(strings.NewReader("url,some-column\n"), net.ManifestFormatCSV)
+				assert.EqualError(err, "line 1: some-column: unknown column")
+
+				_, err = net.ParseManifest(strings.NewReader("url,path\nhttps://example.com/some-file\n"), net.ManifestFormatCSV)
+				assert.EqualError(err, "line 2: wrong number of fields")
+			})
+		})
+
+		context("URL list", func() {
+			it("parses a URL on each line", func() {
+				fileDownloads, err := net.ParseManifest(strings.NewReader(strings.Join([]string{
+					"# some comment",
+					"https://example.com/some-file",
+					"",
+					"  https://example.com/some-other-file  ",
+					"some-file",
+				}, "\n")), net.ManifestFormatURLList)
+				assert.EqualError(err, `line 5: url: expected an http or https URL, got "some-file"`)
+				assert.Nil(fileDownloads)
+
+				fileDownloads, err = net.ParseManifest(strings.NewReader("https://example.com/some-file\nhttps://example.com/some-other-file\n"), net.ManifestFormatURLList)
+				require.NoError(err)
+				assert.Equal([]net.FileDownload{
+					{URL: "https://example.com/some-file", Dir: "."},
+					{URL: "https://example.com/some-other-file", Dir: "."},
+				}, fileDownloads)
+			})
+		})
+	})
+
+	context("ReadManifest", func() {
+		it("detects the format from the extension", func() {
+			tempDir := t.TempDir()
+
+			files := map[string]string{
+				"manifest.json": `[{"url": "https://example.com/some-file"}]`,
+				"manifest.csv":  "url\nhttps://example.com/some-file\n",
+				"urls.txt":      "https://example.com/some-file\n",
+			}
+			for name, contents := range files {
+				path := filepath.Join(tempDir, name)
+				require.NoError(os.WriteFile(path, []byte(contents), 0644))
+
+				fileDownloads, err := net.ReadManifest(path)
+				require.NoError(err, name)
+				assert.Equal([]net.FileDownload{{URL: "https://example.com/some-file", Dir: "."}}, fileDownloads, name)
+			}
+		})
+
+		it("includes the path in errors", func() {
+			path := filepath.Join(t.TempDir(), "manifest.json")
+			require.NoError(os.WriteFile(path, []byte(`[{}]`), 0644))
+
+			_, err := net.ReadManifest(path)
+			assert.EqualError(err, "invalid manifest "+path+":\nline 1: url: is required")
+		})
+	})
+}
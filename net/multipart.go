@@ -0,0 +1,315 @@
+package net
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MultipartFile is a file part of a multipart/form-data request body. Its
+// contents are read from Path, or else Reader.
+type MultipartFile struct {
+	FieldName string
+	// FileName defaults to the base name of Path.
+	FileName string
+	// ContentType defaults to the type of the extension of FileName, or else
+	// application/octet-stream.
+	ContentType string
+	Path        string
+	// Reader can only be sent again when a request is retried if it is an
+	// io.Seeker.
+	Reader io.Reader
+}
+
+// PostMultipart sends a multipart/form-data request with the fields and
+// files. The body is streamed, so files are not read into memory, and it is
+// rebuilt from the start when the request is retried. The upload can be
+// followed with WithUploadProgress.
+func (b *Browser) PostMultipart(url string, fields url.Values, files []MultipartFile, options ...RequestOption) (resp *http.Response, err error) {
+	body, err := newMultipartBody(fields, files)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := body.size()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := body.open()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, reader)
+	if err != nil {
+		_ = reader.Close()
+		return nil, err
+	}
+
+	req.ContentLength = size
+	req.GetBody = body.open
+
+	b.setHeaders(req)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+body.boundary)
+
+	return b.Do(req, options...)
+}
+
+type multipartBody struct {
+	boundary string
+	fields   url.Values
+	files    []multipartFile
+
+	// mu locks the copy of the body which was opened last, as GetBody can be
+	// called from the transport's goroutines.
+	mu     sync.Mutex
+	opened bool
+	// previous is the pipe of the last copy, and written is closed once it
+	// has been written, so that readers are not seeked while it reads them.
+	previous *io.PipeReader
+	written  chan struct{}
+}
+
+// errBodyReopened stops writing a copy of the body once another is opened.
+var errBodyReopened = errors.New("multipart body was opened again")
+
+type multipartFile struct {
+	MultipartFile
+	// offset is where the contents of a seekable Reader start.
+	offset int64
+}
+
+func newMultipartBody(fields url.Values, files []MultipartFile) (*multipartBody, error) {
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	body := &multipartBody{boundary: boundary, fields: fields}
+	for _, file := range files {
+		if file.FieldName == "" {
+			return nil, errors.New("multipart file is missing a field name")
+		}
+		if file.Path == "" && file.Reader == nil {
+			return nil, fmt.Errorf("multipart file %s has neither a path nor a reader", file.FieldName)
+		}
+
+		if file.FileName == "" && file.Path != "" {
+			file.FileName = filepath.Base(file.Path)
+		}
+		if file.ContentType == "" {
+			file.ContentType = mime.TypeByExtension(filepath.Ext(file.FileName))
+		}
+		if file.ContentType == "" {
+			file.ContentType = "application/octet-stream"
+		}
+
+		var offset int64
+		if seeker, ok := file.Reader.(io.Seeker); ok && file.Path == "" {
+			offset, err = seeker.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, fmt.Errorf("failed to seek %s: %w", file.FileName, err)
+			}
+		}
+
+		body.files = append(body.files, multipartFile{MultipartFile: file, offset: offset})
+	}
+
+	return body, nil
+}
+
+// randomBoundary returns a boundary like the ones multipart.Writer generates.
+// It is generated up front so that every copy of the body is identical.
+func randomBoundary() (string, error) {
+	var buf [30]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", buf[:]), nil
+}
+
+// size returns the length of the body, or -1 if the size of a Reader cannot be
+// determined.
+func (b *multipartBody) size() (int64, error) {
+	counter := &countingWriter{}
+	writer := multipart.NewWriter(counter)
+	if err := writer.SetBoundary(b.boundary); err != nil {
+		return 0, err
+	}
+
+	if err := b.writeFields(writer); err != nil {
+		return 0, err
+	}
+
+	for _, file := range b.files {
+		if _, err := writer.CreatePart(file.header()); err != nil {
+			return 0, err
+		}
+
+		size, err := file.size()
+		if err != nil {
+			return 0, err
+		}
+		if size < 0 {
+			return -1, nil
+		}
+		counter.n += size
+	}
+
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+
+	return counter.n, nil
+}
+
+// open returns a new copy of the body, which is written as it is read.
+func (b *multipartBody) open() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	replay := b.opened
+	b.opened = true
+
+	if b.previous != nil {
+		_ = b.previous.CloseWithError(errBodyReopened)
+		<-b.written
+	}
+
+	for _, file := range b.files {
+		if replay && file.Path == "" {
+			seeker, ok := file.Reader.(io.Seeker)
+			if !ok {
+				return nil, fmt.Errorf("cannot send %s again as its reader is not seekable", file.FileName)
+			}
+			if _, err := seeker.Seek(file.offset, io.SeekStart); err != nil {
+				return nil, fmt.Errorf("failed to seek %s: %w", file.FileName, err)
+			}
+		}
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		pipeWriter.CloseWithError(b.write(pipeWriter))
+	}()
+	b.previous = pipeReader
+	b.written = written
+
+	return pipeReader, nil
+}
+
+func (b *multipartBody) write(w io.Writer) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(b.boundary); err != nil {
+		return err
+	}
+
+	if err := b.writeFields(writer); err != nil {
+		return err
+	}
+
+	for _, file := range b.files {
+		part, err := writer.CreatePart(file.header())
+		if err != nil {
+			return err
+		}
+
+		if err := file.copyTo(part); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+func (b *multipartBody) writeFields(writer *multipart.Writer) error {
+	names := make([]string, 0, len(b.fields))
+	for name := range b.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range b.fields[name] {
+			if err := writer.WriteField(name, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (f multipartFile) header() textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(f.FieldName), quoteEscaper.Replace(f.FileName)))
+	header.Set("Content-Type", f.ContentType)
+	return header
+}
+
+func (f multipartFile) size() (int64, error) {
+	if f.Path != "" {
+		fileInfo, err := os.Stat(f.Path)
+		if err != nil {
+			return 0, err
+		}
+		return fileInfo.Size(), nil
+	}
+
+	seeker, ok := f.Reader.(io.Seeker)
+	if !ok {
+		return -1, nil
+	}
+
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to seek %s: %w", f.FileName, err)
+	}
+	if _, err := seeker.Seek(f.offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek %s: %w", f.FileName, err)
+	}
+
+	return end - f.offset, nil
+}
+
+func (f multipartFile) copyTo(w io.Writer) error {
+	if f.Path == "" {
+		_, err := io.Copy(w, f.Reader)
+		return err
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
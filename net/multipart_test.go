@@ -0,0 +1,239 @@
+package net_test
+
+import (
+	"bytes"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMultipart(t *testing.T) {
+	spec.Run(t, "Multipart", testMultipart, spec.Report(report.Terminal{}))
+}
+
+type multipartUpload struct {
+	contentLength int64
+	fields        url.Values
+	files         map[string]multipartUploadFile
+}
+
+type multipartUploadFile struct {
+	fileName    string
+	contentType string
+	contents    string
+}
+
+func testMultipart(t *testing.T, context spec.G, it spec.S) {
+	var (
+		server *httptest.Server
+
+		mu            sync.Mutex
+		uploads       []multipartUpload
+		failedUploads int
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		uploads = nil
+		failedUploads = 0
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err := r.ParseMultipartForm(1024); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			upload := multipartUpload{
+				contentLength: r.ContentLength,
+				fields:        url.Values(r.MultipartForm.Value),
+				files:         map[string]multipartUploadFile{},
+			}
+			for name, fileHeaders := range r.MultipartForm.File {
+				file, err := fileHeaders[0].Open()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				contents, _ := io.ReadAll(file)
+				_ = file.Close()
+
+				upload.files[name] = multipartUploadFile{
+					fileName:    fileHeaders[0].Filename,
+					contentType: fileHeaders[0].Header.Get("Content-Type"),
+					contents:    string(contents),
+				}
+			}
+			uploads = append(uploads, upload)
+
+			if failedUploads > 0 {
+				failedUploads--
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	it("streams fields and files from paths and readers", func() {
+		path := filepath.Join(t.TempDir(), "some-file.json")
+		require.NoError(os.WriteFile(path, []byte(`{"some": "json"}`), 0644))
+
+		browser := net.NewBrowser()
+		resp, err := browser.PostMultipart(server.URL, url.Values{
+			"some-field":       {"some-value", "some-other-value"},
+			"some-other-field": {"some-value"},
+		}, []net.MultipartFile{
+			{FieldName: "some-file", Path: path},
+			{FieldName: "some-reader", FileName: `some "quoted" name`, ContentType: "text/plain", Reader: strings.NewReader("some-contents")},
+		})
+		require.NoError(err)
+		require.NoError(resp.Body.Close())
+		require.Equal(http.StatusOK, resp.StatusCode)
+
+		require.Len(uploads, 1)
+		assert.Greater(uploads[0].contentLength, int64(0))
+		assert.Equal(url.Values{
+			"some-field":       {"some-value", "some-other-value"},
+			"some-other-field": {"some-value"},
+		}, uploads[0].fields)
+		assert.Equal(map[string]multipartUploadFile{
+			"some-file":   {fileName: "some-file.json", contentType: "application/json", contents: `{"some": "json"}`},
+			"some-reader": {fileName: `some "quoted" name`, contentType: "text/plain", contents: "some-contents"},
+		}, uploads[0].files)
+	})
+
+	it("reports upload progress", func() {
+		contents := bytes.Repeat([]byte("0123456789"), 100000)
+
+		var progresses []net.UploadProgress
+		browser := net.NewBrowser()
+		resp, err := browser.PostMultipart(server.URL, nil, []net.MultipartFile{
+			{FieldName: "some-file", FileName: "some-file", Reader: bytes.NewReader(contents)},
+		}, net.WithUploadProgress(func(progress net.UploadProgress) {
+			progresses = append(progresses, progress)
+		}))
+		require.NoError(err)
+		require.NoError(resp.Body.Close())
+
+		require.Greater(len(progresses), 1)
+		lastProgress := progresses[len(progresses)-1]
+		assert.True(lastProgress.Done)
+		assert.Equal(uploads[0].contentLength, lastProgress.TotalBytes)
+		assert.Equal(lastProgress.TotalBytes, lastProgress.UploadedBytes)
+		assert.Equal(1, lastProgress.Attempt)
+		for i := 1; i < len(progresses); i++ {
+			assert.Greater(progresses[i].UploadedBytes, progresses[i-1].UploadedBytes)
+		}
+	})
+
+	it("sends the body again when the request is retried", func() {
+		failedUploads = 1
+		path := filepath.Join(t.TempDir(), "some-file")
+		require.NoError(os.WriteFile(path, []byte("some-file-contents"), 0644))
+
+		var progresses []net.UploadProgress
+		browser := net.NewBrowser(net.WithDefaultRetrier(net.ExponentialBackoffRetrier{MaxAttempts: 2}))
+		resp, err := browser.PostMultipart(server.URL, url.Values{"some-field": {"some-value"}}, []net.MultipartFile{
+			{FieldName: "some-file", Path: path},
+			{FieldName: "some-reader", FileName: "some-reader", Reader: strings.NewReader("some-reader-contents")},
+		}, net.WithUploadProgress(func(progress net.UploadProgress) {
+			progresses = append(progresses, progress)
+		}))
+		require.NoError(err)
+		require.NoError(resp.Body.Close())
+		assert.Equal(http.StatusOK, resp.StatusCode)
+
+		require.Len(uploads, 2)
+		assert.Equal(uploads[0], uploads[1])
+		assert.Equal("some-reader-contents", uploads[1].files["some-reader"].contents)
+
+		lastProgress := progresses[len(progresses)-1]
+		assert.Equal(2, lastProgress.Attempt)
+		assert.True(lastProgress.Done)
+		assert.Equal(uploads[1].contentLength, lastProgress.UploadedBytes)
+	})
+
+	it("stops writing the previous body before it is sent again", func() {
+		var attempts int
+		var received []byte
+		earlyFailureServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts++
+			if attempts == 1 {
+				// Fail without reading the body, so it is still being written
+				// when the request is retried.
+				w.Header().Set("Connection", "close")
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			received, _ = io.ReadAll(r.Body)
+		}))
+		defer earlyFailureServer.Close()
+
+		contents := bytes.Repeat([]byte("some-contents"), 1024*1024)
+		browser := net.NewBrowser(net.WithDefaultRetrier(net.ExponentialBackoffRetrier{MaxAttempts: 2}))
+		resp, err := browser.PostMultipart(earlyFailureServer.URL, nil, []net.MultipartFile{
+			{FieldName: "some-reader", FileName: "some-reader", Reader: bytes.NewReader(contents)},
+		})
+		require.NoError(err)
+		require.NoError(resp.Body.Close())
+
+		assert.Equal(2, attempts)
+		assert.True(bytes.Contains(received, contents))
+	})
+
+	it("sends readers of unknown size chunked but cannot retry them", func() {
+		failedUploads = 1
+
+		var progresses []net.UploadProgress
+		browser := net.NewBrowser(net.WithDefaultRetrier(net.ExponentialBackoffRetrier{MaxAttempts: 2}))
+		_, err := browser.PostMultipart(server.URL, nil, []net.MultipartFile{
+			{FieldName: "some-reader", FileName: "some-reader", Reader: io.MultiReader(strings.NewReader("some-contents"))},
+		}, net.WithUploadProgress(func(progress net.UploadProgress) {
+			progresses = append(progresses, progress)
+		}))
+		assert.EqualError(err, "failed to replay request body: cannot send some-reader again as its reader is not seekable")
+
+		require.Len(uploads, 1)
+		assert.Equal(int64(-1), uploads[0].contentLength)
+		assert.Equal("some-contents", uploads[0].files["some-reader"].contents)
+
+		require.NotEmpty(progresses)
+		assert.Equal(int64(-1), progresses[0].TotalBytes)
+		assert.True(progresses[len(progresses)-1].Done)
+	})
+
+	it("returns an error for invalid files", func() {
+		browser := net.NewBrowser()
+
+		_, err := browser.PostMultipart(server.URL, nil, []net.MultipartFile{{Path: "some-file"}})
+		assert.EqualError(err, "multipart file is missing a field name")
+
+		_, err = browser.PostMultipart(server.URL, nil, []net.MultipartFile{{FieldName: "some-file"}})
+		assert.EqualError(err, "multipart file some-file has neither a path nor a reader")
+
+		_, err = browser.PostMultipart(server.URL, nil, []net.MultipartFile{{FieldName: "some-file", Path: filepath.Join(t.TempDir(), "missing-file")}})
+		assert.ErrorIs(err, os.ErrNotExist)
+		assert.Empty(uploads)
+	})
+}
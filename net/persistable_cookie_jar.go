@@ -28,15 +28,33 @@ type PersistableCookieJar struct {
 	// nextSeqNum is the next sequence number assigned to a new cookie
 	// created SetCookies.
 	nextSeqNum uint64
+
+	// onChange is called with mu held whenever cookies are added, changed or
+	// removed, other than by expiring.
+	onChange func()
+
+	// The limits are not enforced when they are 0.
+	maxCookiesPerDomain int
+	maxCookies          int
+	maxCookieSize       int
+	onEvict             func(e JarEntry, reason CookieEvictionReason)
+
+	laxByDefault bool
 }
 
-func NewPersistableCookieJar(o *cookiejar.Options) *PersistableCookieJar {
+// NewPersistableCookieJar returns an empty jar. It uses the public suffix list
+// in o, or the one returned by DefaultPublicSuffixList if there is none.
+func NewPersistableCookieJar(o *cookiejar.Options, options ...JarOption) *PersistableCookieJar {
 	jar := &PersistableCookieJar{
 		entries: make(map[string]map[string]JarEntry),
+		psList:  DefaultPublicSuffixList(),
 	}
-	if o != nil {
+	if o != nil && o.PublicSuffixList != nil {
 		jar.psList = o.PublicSuffixList
 	}
+	for _, option := range options {
+		option(jar)
+	}
 	return jar
 }
 
@@ -105,8 +123,17 @@ func (j *PersistableCookieJar) Export() map[string]map[string]JarEntry {
 	return j.entries
 }
 
+// Import replaces the entries in the jar, evicting any which are over its
+// limits.
 func (j *PersistableCookieJar) Import(entries map[string]map[string]JarEntry) {
+	var evictions []cookieEviction
+	defer func() { j.reportEvictions(evictions) }()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
 	j.entries = entries
+	evictions = j.evictImported(time.Now())
 
 	var maxSeqNum uint64
 	for _, e := range entries {
@@ -121,13 +148,15 @@ func (j *PersistableCookieJar) Import(entries map[string]map[string]JarEntry) {
 
 // Cookies implements the Cookies method of the http.CookieJar interface.
 //
-// It returns an empty slice if the URL's scheme is not HTTP or HTTPS.
+// It returns an empty slice if the URL's scheme is not HTTP or HTTPS. SameSite
+// attributes are not enforced; use InContext for that.
 func (j *PersistableCookieJar) Cookies(u *url.URL) (cookies []*http.Cookie) {
-	return j.cookies(u, time.Now())
+	return j.cookies(u, nil, time.Now())
 }
 
-// cookies is like Cookies but takes the current time as a parameter.
-func (j *PersistableCookieJar) cookies(u *url.URL, now time.Time) (cookies []*http.Cookie) {
+// cookies is like Cookies but takes the current time as a parameter. SameSite
+// attributes are enforced if sameSite is not nil.
+func (j *PersistableCookieJar) cookies(u *url.URL, sameSite *SameSiteContext, now time.Time) (cookies []*http.Cookie) {
 	if u.Scheme != "http" && u.Scheme != "https" {
 		return cookies
 	}
@@ -150,6 +179,7 @@ func (j *PersistableCookieJar) cookies(u *url.URL, now time.Time) (cookies []*ht
 	if path == "" {
 		path = "/"
 	}
+	crossSite := j.crossSite(u, sameSite)
 
 	modified := false
 	var selected []JarEntry
@@ -162,6 +192,9 @@ func (j *PersistableCookieJar) cookies(u *url.URL, now time.Time) (cookies []*ht
 		if !e.shouldSend(https, host, path) {
 			continue
 		}
+		if crossSite && !j.sendCrossSite(e, sameSite) {
+			continue
+		}
 		e.LastAccess = now
 		submap[id] = e
 		selected = append(selected, e)
@@ -198,11 +231,12 @@ func (j *PersistableCookieJar) cookies(u *url.URL, now time.Time) (cookies []*ht
 //
 // It does nothing if the URL's scheme is not HTTP or HTTPS.
 func (j *PersistableCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
-	j.setCookies(u, cookies, time.Now())
+	j.setCookies(u, cookies, nil, time.Now())
 }
 
 // setCookies is like SetCookies but takes the current time as parameter.
-func (j *PersistableCookieJar) setCookies(u *url.URL, cookies []*http.Cookie, now time.Time) {
+// SameSite attributes are enforced if sameSite is not nil.
+func (j *PersistableCookieJar) setCookies(u *url.URL, cookies []*http.Cookie, sameSite *SameSiteContext, now time.Time) {
 	if len(cookies) == 0 {
 		return
 	}
@@ -215,6 +249,13 @@ func (j *PersistableCookieJar) setCookies(u *url.URL, cookies []*http.Cookie, no
 	}
 	key := jarKey(host, j.psList)
 	defPath := defaultPath(u.Path)
+	https := u.Scheme == "https"
+	// Responses to cross-site requests for subresources cannot set cookies
+	// which would not be sent with them.
+	crossSiteSubresource := j.crossSite(u, sameSite) && !sameSite.Navigation
+
+	var evictions []cookieEviction
+	defer func() { j.reportEvictions(evictions) }()
 
 	j.mu.Lock()
 	defer j.mu.Unlock()
@@ -223,11 +264,20 @@ func (j *PersistableCookieJar) setCookies(u *url.URL, cookies []*http.Cookie, no
 
 	modified := false
 	for _, cookie := range cookies {
+		if !validCookie(cookie, https) {
+			continue
+		}
+		if crossSiteSubresource && j.enforcedSameSite(sameSiteAttribute(cookie)) != http.SameSiteNoneMode {
+			continue
+		}
 		e, remove, err := j.newEntry(cookie, now, defPath, host)
 		if err != nil {
 			continue
 		}
 		id := e.id()
+		if !remove && j.tooLarge(e.Name, e.Value) {
+			continue
+		}
 		if remove {
 			if submap != nil {
 				if _, ok := submap[id]; ok {
@@ -260,6 +310,16 @@ func (j *PersistableCookieJar) setCookies(u *url.URL, cookies []*http.Cookie, no
 		} else {
 			j.entries[key] = submap
 		}
+		j.changed()
+		evictions = j.evict([]string{key}, now)
+	}
+}
+
+// changed reports a change to the cookies to onChange. It must be called with
+// mu held.
+func (j *PersistableCookieJar) changed() {
+	if j.onChange != nil {
+		j.onChange()
 	}
 }
 
@@ -395,14 +455,7 @@ func (j *PersistableCookieJar) newEntry(c *http.Cookie, now time.Time, defPath,
 	e.Secure = c.Secure
 	e.HttpOnly = c.HttpOnly
 
-	switch c.SameSite {
-	case http.SameSiteDefaultMode:
-		e.SameSite = "SameSite"
-	case http.SameSiteStrictMode:
-		e.SameSite = "SameSite=Strict"
-	case http.SameSiteLaxMode:
-		e.SameSite = "SameSite=Lax"
-	}
+	e.SameSite = sameSiteAttribute(c)
 
 	return e, false, nil
 }
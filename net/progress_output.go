@@ -0,0 +1,228 @@
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mdelillo/go-utils/ansi"
+	"io"
+	"time"
+)
+
+// ProgressMode is how DownloadFilesWithProgress writes progress.
+type ProgressMode string
+
+const (
+	// ProgressModeAuto uses ProgressModeInteractive when writing to a
+	// terminal and ProgressModePlain otherwise.
+	ProgressModeAuto ProgressMode = "auto"
+	// ProgressModeInteractive redraws a progress bar for each download in
+	// place, using ANSI escape codes.
+	ProgressModeInteractive ProgressMode = "interactive"
+	// ProgressModePlain writes log lines when the state of a download changes
+	// and periodically while it downloads.
+	ProgressModePlain ProgressMode = "plain"
+	// ProgressModeJSON writes a DownloadProgressEvent as a line of JSON when
+	// the state of a download changes and periodically while it downloads.
+	ProgressModeJSON ProgressMode = "json"
+)
+
+const (
+	defaultPlainProgressInterval = 5 * time.Second
+	defaultJSONProgressInterval  = time.Second
+)
+
+// DownloadProgressEvent is the progress of a download as it is written in
+// ProgressModeJSON.
+type DownloadProgressEvent struct {
+	Time            time.Time     `json:"time"`
+	Index           int           `json:"index"`
+	URL             string        `json:"url"`
+	FilePath        string        `json:"file_path,omitempty"`
+	State           DownloadState `json:"state"`
+	TotalBytes      int64         `json:"total_bytes"`
+	DownloadedBytes int64         `json:"downloaded_bytes"`
+	BytesPerSecond  float64       `json:"bytes_per_second"`
+	ETASeconds      float64       `json:"eta_seconds,omitempty"`
+	Attempts        int           `json:"attempts"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// progressOutput writes the progress of downloads. Its methods are not called
+// concurrently.
+type progressOutput interface {
+	update(progress DownloadProgress)
+	// flush writes any progress that has not been written once the downloads
+	// are done.
+	flush()
+}
+
+func newProgressOutput(fileDownloads []FileDownload, opts *downloadOptions) progressOutput {
+	mode := opts.mode
+	if mode == ProgressModeAuto || mode == "" {
+		if ansi.IsTerminal(opts.writer) {
+			mode = ProgressModeInteractive
+		} else {
+			mode = ProgressModePlain
+		}
+	}
+
+	switch mode {
+	case ProgressModePlain:
+		interval := opts.progressInterval
+		if interval == 0 {
+			interval = defaultPlainProgressInterval
+		}
+		return &plainProgressOutput{writer: opts.writer, throttle: newProgressThrottle(interval)}
+	case ProgressModeJSON:
+		interval := opts.progressInterval
+		if interval == 0 {
+			interval = defaultJSONProgressInterval
+		}
+		return &jsonProgressOutput{encoder: json.NewEncoder(opts.writer), throttle: newProgressThrottle(interval)}
+	default:
+		return &downloadsProgresses{
+			fileDownloads: fileDownloads,
+			writer: &ansi.InPlaceWriter{
+				Writer:    opts.writer,
+				LineCount: len(fileDownloads),
+			},
+		}
+	}
+}
+
+// progressThrottle decides which progress updates are written: every change
+// of state, and updates in the downloading state at most once per interval
+// for each download.
+type progressThrottle struct {
+	interval    time.Duration
+	states      map[int]DownloadState
+	lastWritten map[int]time.Time
+}
+
+func newProgressThrottle(interval time.Duration) *progressThrottle {
+	return &progressThrottle{
+		interval:    interval,
+		states:      map[int]DownloadState{},
+		lastWritten: map[int]time.Time{},
+	}
+}
+
+// allow reports whether the update should be written, along with whether it
+// is a change of state.
+func (t *progressThrottle) allow(progress DownloadProgress) (bool, bool) {
+	now := time.Now()
+
+	if t.states[progress.Index] != progress.State {
+		t.states[progress.Index] = progress.State
+		t.lastWritten[progress.Index] = now
+		return true, true
+	}
+
+	if progress.State == DownloadStateDownloading && now.Sub(t.lastWritten[progress.Index]) >= t.interval {
+		t.lastWritten[progress.Index] = now
+		return true, false
+	}
+
+	return false, false
+}
+
+type plainProgressOutput struct {
+	writer   io.Writer
+	throttle *progressThrottle
+}
+
+func (o *plainProgressOutput) update(progress DownloadProgress) {
+	allowed, stateChanged := o.throttle.allow(progress)
+	if !allowed {
+		return
+	}
+
+	name := progress.FileDownload.name()
+
+	var line string
+	switch progress.State {
+	case DownloadStateDownloading:
+		if stateChanged {
+			line = fmt.Sprintf("Downloading %s from %s", name, progress.URL)
+		} else {
+			line = fmt.Sprintf("Downloading %s: %s", name, formatProgress(progress))
+		}
+	case DownloadStateRetrying:
+		line = fmt.Sprintf("Retrying %s (attempt %d)", name, progress.Attempts)
+	case DownloadStateVerifying:
+		line = fmt.Sprintf("Verifying %s", name)
+	case DownloadStateExtracting:
+		line = fmt.Sprintf("Extracting %s", name)
+	case DownloadStateDone:
+		line = fmt.Sprintf("Downloaded %s (%s in %s)", progress.FileDownload.location(), formatFileSize(float64(progress.DownloadedBytes)), progress.DownloadTime.Round(time.Millisecond))
+	case DownloadStateSkipped:
+		line = fmt.Sprintf("%s is up to date (%s)", progress.FileDownload.location(), formatFileSize(float64(progress.TotalBytes)))
+	case DownloadStateFailed:
+		line = fmt.Sprintf("Failed to download %s: %s", name, progress.Err)
+	case DownloadStateCancelled:
+		line = fmt.Sprintf("Cancelled %s (%s downloaded)", name, formatFileSize(float64(progress.DownloadedBytes)))
+	default:
+		// Queued, probing and connecting are too brief to be worth a line.
+		return
+	}
+
+	_, _ = fmt.Fprintln(o.writer, line)
+}
+
+func (o *plainProgressOutput) flush() {}
+
+// formatProgress describes how far along a download is, like
+// "45% (4.5MB/10MB, 1.2MB/s, 5s remaining)".
+func formatProgress(progress DownloadProgress) string {
+	speed := formatFileSize(progress.BytesPerSecond) + "/s"
+	if progress.TotalBytes < 0 {
+		return fmt.Sprintf("%s (%s)", formatFileSize(float64(progress.DownloadedBytes)), speed)
+	}
+
+	var percentComplete int64
+	if progress.TotalBytes != 0 {
+		percentComplete = progress.DownloadedBytes * 100 / progress.TotalBytes
+	}
+
+	return fmt.Sprintf("%d%% (%s/%s, %s, %s remaining)",
+		percentComplete,
+		formatFileSize(float64(progress.DownloadedBytes)),
+		formatFileSize(float64(progress.TotalBytes)),
+		speed,
+		progress.ETA,
+	)
+}
+
+type jsonProgressOutput struct {
+	encoder  *json.Encoder
+	throttle *progressThrottle
+}
+
+func (o *jsonProgressOutput) update(progress DownloadProgress) {
+	if allowed, _ := o.throttle.allow(progress); !allowed {
+		return
+	}
+
+	event := DownloadProgressEvent{
+		Time:            time.Now().UTC(),
+		Index:           progress.Index,
+		URL:             progress.URL,
+		FilePath:        progress.FileDownload.FilePath,
+		State:           progress.State,
+		TotalBytes:      progress.TotalBytes,
+		DownloadedBytes: progress.DownloadedBytes,
+		BytesPerSecond:  progress.BytesPerSecond,
+		ETASeconds:      progress.ETA.Seconds(),
+		Attempts:        progress.Attempts,
+	}
+	if event.URL == "" {
+		event.URL = progress.FileDownload.URL
+	}
+	if progress.Err != nil {
+		event.Error = progress.Err.Error()
+	}
+
+	_ = o.encoder.Encode(event)
+}
+
+func (o *jsonProgressOutput) flush() {}
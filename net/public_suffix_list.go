@@ -0,0 +1,184 @@
+package net
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// publicSuffixListData is the list from https://publicsuffix.org/list/, as of
+// when it was last copied into the repository. LoadPublicSuffixList can load a
+// newer copy.
+//
+//go:embed public_suffix_list.dat
+var publicSuffixListData string
+
+type publicSuffixRuleKind uint8
+
+const (
+	// publicSuffixRuleNormal is a rule like "co.uk".
+	publicSuffixRuleNormal publicSuffixRuleKind = 1 << iota
+	// publicSuffixRuleWildcard is a rule like "*.ck", which makes every
+	// subdomain of "ck" a public suffix.
+	publicSuffixRuleWildcard
+	// publicSuffixRuleException is a rule like "!www.ck", which makes
+	// "www.ck" registrable despite a wildcard rule.
+	publicSuffixRuleException
+)
+
+type publicSuffixRule struct {
+	kinds publicSuffixRuleKind
+	icann bool
+}
+
+// PublicSuffixList implements cookiejar.PublicSuffixList with the rules of a
+// Public Suffix List, in the format of https://publicsuffix.org/list/. It
+// keeps cookies from being set for domains like "co.uk" which are shared by
+// unrelated sites.
+type PublicSuffixList struct {
+	source string
+	// rules are keyed by the domain they apply to, without any "*." or "!"
+	// prefix, in its ASCII form.
+	rules map[string]publicSuffixRule
+}
+
+var (
+	defaultPublicSuffixList     *PublicSuffixList
+	defaultPublicSuffixListOnce sync.Once
+)
+
+// DefaultPublicSuffixList returns the list embedded in the package, which jars
+// use when they are not given one.
+func DefaultPublicSuffixList() *PublicSuffixList {
+	defaultPublicSuffixListOnce.Do(func() {
+		list, err := ParsePublicSuffixList("embedded publicsuffix.org list", strings.NewReader(publicSuffixListData))
+		if err != nil {
+			panic(fmt.Sprintf("embedded public suffix list is invalid: %s", err.Error()))
+		}
+		defaultPublicSuffixList = list
+	})
+	return defaultPublicSuffixList
+}
+
+// LoadPublicSuffixList reads a list from a file, such as a newer copy of
+// https://publicsuffix.org/list/public_suffix_list.dat than the one embedded
+// in the package.
+func LoadPublicSuffixList(path string) (*PublicSuffixList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open public suffix list: %w", err)
+	}
+	defer file.Close()
+
+	return ParsePublicSuffixList(path, file)
+}
+
+// ParsePublicSuffixList reads a list in the format of
+// https://publicsuffix.org/list/. source describes where the list came from,
+// and is returned by String. Rules outside of the ICANN section are treated
+// as private, as they are in the private section.
+func ParsePublicSuffixList(source string, reader io.Reader) (*PublicSuffixList, error) {
+	list := &PublicSuffixList{source: source, rules: map[string]publicSuffixRule{}}
+
+	scanner := bufio.NewScanner(reader)
+	icann := false
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.Contains(line, "===BEGIN ICANN DOMAINS==="):
+			icann = true
+			continue
+		case strings.Contains(line, "===END ICANN DOMAINS==="):
+			icann = false
+			continue
+		case line == "" || strings.HasPrefix(line, "//"):
+			continue
+		}
+
+		// Rules end at the first whitespace, and anything after it is
+		// ignored.
+		rule := strings.Fields(line)[0]
+
+		kind := publicSuffixRuleNormal
+		if strings.HasPrefix(rule, "!") {
+			kind = publicSuffixRuleException
+			rule = rule[1:]
+		} else if strings.HasPrefix(rule, "*.") {
+			kind = publicSuffixRuleWildcard
+			rule = rule[2:]
+		}
+
+		domain, err := toASCII(strings.ToLower(rule))
+		if err != nil || domain == "" || strings.Contains(domain, "*") {
+			return nil, fmt.Errorf("invalid rule on line %d: %q", lineNumber, line)
+		}
+
+		existing := list.rules[domain]
+		list.rules[domain] = publicSuffixRule{
+			kinds: existing.kinds | kind,
+			icann: existing.icann || icann,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read public suffix list: %w", err)
+	}
+
+	if len(list.rules) == 0 {
+		return nil, errors.New("public suffix list has no rules")
+	}
+
+	return list, nil
+}
+
+// PublicSuffix returns the public suffix of domain, which must be in its
+// canonical ASCII form, as required by cookiejar.PublicSuffixList. The last
+// label of domains which do not match any rule is their public suffix.
+func (l *PublicSuffixList) PublicSuffix(domain string) string {
+	suffix, _ := l.Lookup(domain)
+	return suffix
+}
+
+// Lookup returns the public suffix of domain, like PublicSuffix, along with
+// whether it comes from a rule in the ICANN section of the list rather than
+// the private section or the default rule for unlisted domains.
+func (l *PublicSuffixList) Lookup(domain string) (suffix string, icann bool) {
+	// Suffixes are checked from the longest, so the first rule which matches
+	// is the prevailing one.
+	for suffix := domain; ; {
+		dot := strings.IndexByte(suffix, '.')
+
+		rule := l.rules[suffix]
+		if rule.kinds&publicSuffixRuleException != 0 {
+			if dot < 0 {
+				return suffix, rule.icann
+			}
+			return suffix[dot+1:], rule.icann
+		}
+		if rule.kinds&publicSuffixRuleNormal != 0 {
+			return suffix, rule.icann
+		}
+		if dot < 0 {
+			return suffix, false
+		}
+
+		parent := suffix[dot+1:]
+		if parentRule := l.rules[parent]; parentRule.kinds&publicSuffixRuleWildcard != 0 {
+			return suffix, parentRule.icann
+		}
+
+		suffix = parent
+	}
+}
+
+// String describes where the list came from.
+func (l *PublicSuffixList) String() string {
+	return l.source
+}
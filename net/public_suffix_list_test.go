@@ -0,0 +1,131 @@
+package net_test
+
+import (
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPublicSuffixList(t *testing.T) {
+	spec.Run(t, "PublicSuffixList", testPublicSuffixList, spec.Report(report.Terminal{}))
+}
+
+func testPublicSuffixList(t *testing.T, context spec.G, it spec.S) {
+	var (
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	context("DefaultPublicSuffixList", func() {
+		var list *net.PublicSuffixList
+
+		it.Before(func() {
+			list = net.DefaultPublicSuffixList()
+		})
+
+		it("applies the rules in the ICANN section", func() {
+			for domain, expectedSuffix := range map[string]string{
+				"com":                       "com",
+				"example.com":               "com",
+				"www.example.co.uk":         "co.uk",
+				"co.uk":                     "co.uk",
+				"www.example.xn--55qx5d.cn": "xn--55qx5d.cn",
+				// "*.ck" makes every subdomain of ck a public suffix...
+				"some-site.some-name.ck": "some-name.ck",
+				// ...except for "!www.ck".
+				"www.ck":                          "ck",
+				"some-site.some-city.kawasaki.jp": "some-city.kawasaki.jp",
+				"www.city.kawasaki.jp":            "kawasaki.jp",
+			} {
+				suffix, icann := list.Lookup(domain)
+				assert.Equal(expectedSuffix, suffix, domain)
+				assert.True(icann, domain)
+				assert.Equal(expectedSuffix, list.PublicSuffix(domain), domain)
+			}
+		})
+
+		it("applies the rules in the private section", func() {
+			for domain, expectedSuffix := range map[string]string{
+				"some-user.github.io":      "github.io",
+				"some-blog.blogspot.com":   "blogspot.com",
+				"some-app.appspot.com":     "appspot.com",
+				"www.some-app.appspot.com": "appspot.com",
+			} {
+				suffix, icann := list.Lookup(domain)
+				assert.Equal(expectedSuffix, suffix, domain)
+				assert.False(icann, domain)
+			}
+		})
+
+		it("uses the last label of domains which are not listed", func() {
+			suffix, icann := list.Lookup("www.example.some-unlisted-tld")
+			assert.Equal("some-unlisted-tld", suffix)
+			assert.False(icann)
+		})
+
+		it("is used by jars by default", func() {
+			jar := net.NewPersistableCookieJar(nil)
+			u, err := url.Parse("https://www.example.co.uk/")
+			require.NoError(err)
+
+			jar.SetCookies(u, []*http.Cookie{
+				{Name: "public-suffix-cookie", Value: "some-value", Domain: "co.uk"},
+				{Name: "site-cookie", Value: "some-value", Domain: "example.co.uk"},
+			})
+
+			otherSite, err := url.Parse("https://www.some-other-example.co.uk/")
+			require.NoError(err)
+			assert.Empty(jar.Cookies(otherSite))
+			assert.Equal([]*http.Cookie{{Name: "site-cookie", Value: "some-value"}}, jar.Cookies(u))
+		})
+	})
+
+	context("LoadPublicSuffixList", func() {
+		it("reads the list from a file", func() {
+			path := filepath.Join(t.TempDir(), "public_suffix_list.dat")
+			require.NoError(os.WriteFile(path, []byte(strings.Join([]string{
+				"// ===BEGIN ICANN DOMAINS===",
+				"example",
+				"*.wildcard.example",
+				"!www.wildcard.example",
+				"// ===END ICANN DOMAINS===",
+				"// ===BEGIN PRIVATE DOMAINS===",
+				"hosting.example some trailing text",
+				"// ===END PRIVATE DOMAINS===",
+			}, "\n")), 0644))
+
+			list, err := net.LoadPublicSuffixList(path)
+			require.NoError(err)
+			assert.Equal(path, list.String())
+
+			suffix, icann := list.Lookup("www.some-site.example")
+			assert.Equal("example", suffix)
+			assert.True(icann)
+
+			assert.Equal("some-site.wildcard.example", list.PublicSuffix("www.some-site.wildcard.example"))
+			assert.Equal("wildcard.example", list.PublicSuffix("www.wildcard.example"))
+
+			suffix, icann = list.Lookup("some-site.hosting.example")
+			assert.Equal("hosting.example", suffix)
+			assert.False(icann)
+
+			assert.Equal("com", list.PublicSuffix("example.com"))
+		})
+
+		it("returns an error for a file without rules", func() {
+			path := filepath.Join(t.TempDir(), "public_suffix_list.dat")
+			require.NoError(os.WriteFile(path, []byte("// Just a comment\n"), 0644))
+
+			_, err := net.LoadPublicSuffixList(path)
+			assert.EqualError(err, "public suffix list has no rules")
+		})
+	})
+}
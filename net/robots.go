@@ -0,0 +1,404 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRobotsCacheDuration is how long robots.txt files are cached by
+// default, which is the longest RFC 9309 recommends.
+const defaultRobotsCacheDuration = 24 * time.Hour
+
+// robotsUnavailableCacheDuration is how long a robots.txt file which could not
+// be fetched because of a server error is cached, during which the host is
+// treated as disallowing everything.
+const robotsUnavailableCacheDuration = time.Minute
+
+// maxRobotsTxtSize is the size of robots.txt files past which the rest of the
+// file is ignored, as RFC 9309 allows.
+const maxRobotsTxtSize = 500 * 1024
+
+// RobotsPolicy makes a Browser comply with the robots.txt file of each host it
+// sends requests to. The file is fetched through the Browser the first time a
+// host is requested and cached. Requests it disallows fail with a
+// *RobotsDisallowedError, and any Crawl-delay it sets is added to the rate
+// limiting of requests to the host.
+type RobotsPolicy struct {
+	// UserAgent is the product token, like "MyBot", matched against the
+	// User-agent lines of robots.txt files. If it is empty, the product token
+	// of the User-Agent header of each request is used.
+	UserAgent string
+	// CacheDuration is how long robots.txt files are cached. It defaults to
+	// 24 hours.
+	CacheDuration time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+func NewRobotsPolicy(userAgent string) *RobotsPolicy {
+	return &RobotsPolicy{UserAgent: userAgent}
+}
+
+// WithRobotsPolicy makes the browser comply with robots.txt files. Requests
+// the policy disallows fail with a *RobotsDisallowedError.
+func WithRobotsPolicy(policy *RobotsPolicy) func(*Browser) {
+	return func(b *Browser) {
+		b.Robots = policy
+	}
+}
+
+type RobotsDisallowedError struct {
+	URL       string
+	UserAgent string
+	// Rule is the Disallow rule that matched the URL, or empty if the
+	// robots.txt file could not be fetched because of a server error.
+	Rule string
+}
+
+func (e *RobotsDisallowedError) Error() string {
+	if e.Rule == "" {
+		return fmt.Sprintf("robots.txt is unavailable, disallowing %s", e.URL)
+	}
+	return fmt.Sprintf("robots.txt disallows %s for %s (Disallow: %s)", e.URL, e.UserAgent, e.Rule)
+}
+
+type robotsHost struct {
+	// ready is closed once the robots.txt file has been fetched.
+	ready     chan struct{}
+	robots    *robotsTxt
+	err       error
+	expiresAt time.Time
+	// rateLimiters enforce the Crawl-delay for each user agent.
+	rateLimiters map[string]*BasicRateLimiter
+}
+
+// check returns an error if the request is disallowed by the robots.txt file
+// of its host, or else the rate limiter which enforces its Crawl-delay, if it
+// has one.
+func (p *RobotsPolicy) check(b *Browser, req *http.Request) (RateLimiter, error) {
+	if req.URL == nil || isRobotsTxtURL(req.URL) {
+		return nil, nil
+	}
+
+	host, err := p.host(req.Context(), b, req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch robots.txt: %w", err)
+	}
+
+	userAgent := p.userAgent(req)
+	group := host.robots.group(userAgent)
+
+	if rule, allowed := group.allows(robotsPath(req.URL)); !allowed {
+		return nil, &RobotsDisallowedError{URL: req.URL.String(), UserAgent: userAgent, Rule: rule}
+	}
+
+	if group.crawlDelay <= 0 {
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	rateLimiter, ok := host.rateLimiters[userAgent]
+	if !ok {
+		rateLimiter = &BasicRateLimiter{RequestDelay: group.crawlDelay}
+		host.rateLimiters[userAgent] = rateLimiter
+	}
+
+	return rateLimiter, nil
+}
+
+// host returns the cached robots.txt file for the host of u, fetching it with
+// ctx if it is not cached or has expired. Concurrent requests to a host wait
+// for a single fetch, unless their ctx is done first.
+func (p *RobotsPolicy) host(ctx context.Context, b *Browser, u *url.URL) (*robotsHost, error) {
+	key := u.Scheme + "://" + u.Host
+
+	p.mu.Lock()
+	if p.hosts == nil {
+		p.hosts = map[string]*robotsHost{}
+	}
+
+	host, ok := p.hosts[key]
+	if ok {
+		select {
+		case <-host.ready:
+			if time.Now().After(host.expiresAt) {
+				ok = false
+			}
+		default:
+		}
+	}
+
+	if ok {
+		p.mu.Unlock()
+		select {
+		case <-host.ready:
+			if isContextError(host.err) && ctx.Err() == nil {
+				// The request which fetched the file was cancelled, but this
+				// one was not, so it fetches the file itself.
+				return p.host(ctx, b, u)
+			}
+			return host, host.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	host = &robotsHost{ready: make(chan struct{}), rateLimiters: map[string]*BasicRateLimiter{}}
+	p.hosts[key] = host
+	p.mu.Unlock()
+
+	host.robots, host.expiresAt, host.err = p.fetch(ctx, b, key+"/robots.txt")
+	if host.err != nil {
+		// Errors are not cached so that the next request tries again.
+		p.mu.Lock()
+		delete(p.hosts, key)
+		p.mu.Unlock()
+	}
+	close(host.ready)
+
+	return host, host.err
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (p *RobotsPolicy) fetch(ctx context.Context, b *Browser, robotsURL string) (*robotsTxt, time.Time, error) {
+	cacheDuration := p.CacheDuration
+	if cacheDuration == 0 {
+		cacheDuration = defaultRobotsCacheDuration
+	}
+
+	resp, err := b.Get(robotsURL, WithContext(ctx))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		robots, err := parseRobotsTxt(io.LimitReader(resp.Body, maxRobotsTxtSize))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return robots, time.Now().Add(cacheDuration), nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		// Hosts without a robots.txt file allow everything.
+		return &robotsTxt{}, time.Now().Add(cacheDuration), nil
+	case resp.StatusCode >= 500:
+		if cacheDuration > robotsUnavailableCacheDuration {
+			cacheDuration = robotsUnavailableCacheDuration
+		}
+		return &robotsTxt{unavailable: true}, time.Now().Add(cacheDuration), nil
+	default:
+		return nil, time.Time{}, fmt.Errorf("got unexpected response: %s", resp.Status)
+	}
+}
+
+func (p *RobotsPolicy) userAgent(req *http.Request) string {
+	userAgent := p.UserAgent
+	if userAgent == "" {
+		userAgent = req.Header.Get("User-Agent")
+	}
+
+	// Only the product token, like "MyBot" in "MyBot/1.0 (+https://...)", is
+	// matched.
+	if i := strings.IndexAny(userAgent, "/ "); i >= 0 {
+		userAgent = userAgent[:i]
+	}
+
+	return strings.ToLower(userAgent)
+}
+
+func isRobotsTxtURL(u *url.URL) bool {
+	return u.Path == "/robots.txt"
+}
+
+// robotsPath returns the part of u that robots.txt rules are matched against.
+func robotsPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+type robotsTxt struct {
+	groups []*robotsGroup
+	// unavailable is set when the file could not be fetched because of a
+	// server error, in which case everything is disallowed.
+	unavailable bool
+}
+
+type robotsGroup struct {
+	userAgents  []string
+	rules       []robotsRule
+	crawlDelay  time.Duration
+	disallowAll bool
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	regexp  *regexp.Regexp
+}
+
+// parseRobotsTxt parses the groups of rules in a robots.txt file. Lines which
+// cannot be parsed are ignored, as RFC 9309 requires.
+func parseRobotsTxt(reader io.Reader) (*robotsTxt, error) {
+	robots := &robotsTxt{}
+
+	var (
+		group *robotsGroup
+		// inUserAgents is set while reading the User-agent lines that start a
+		// group.
+		inUserAgents bool
+	)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRobotsTxtSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inUserAgents {
+				group = &robotsGroup{}
+				robots.groups = append(robots.groups, group)
+				inUserAgents = true
+			}
+			if i := strings.IndexAny(value, "/ "); i >= 0 {
+				value = value[:i]
+			}
+			group.userAgents = append(group.userAgents, strings.ToLower(value))
+		case "allow", "disallow":
+			inUserAgents = false
+			if group == nil || value == "" {
+				continue
+			}
+			group.rules = append(group.rules, robotsRule{
+				allow:   key == "allow",
+				pattern: value,
+				regexp:  robotsPattern(value),
+			})
+		case "crawl-delay":
+			inUserAgents = false
+			if group == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				group.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		default:
+			inUserAgents = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return robots, nil
+}
+
+// robotsPattern compiles a robots.txt path pattern, in which "*" matches any
+// characters and a trailing "$" anchors the pattern to the end of the path.
+func robotsPattern(pattern string) *regexp.Regexp {
+	var anchored bool
+	if strings.HasSuffix(pattern, "$") {
+		pattern = strings.TrimSuffix(pattern, "$")
+		anchored = true
+	}
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+
+	return regexp.MustCompile(expr)
+}
+
+// group returns the rules which apply to userAgent, which are those of every
+// group naming it, or else those of the groups for "*".
+func (r *robotsTxt) group(userAgent string) *robotsGroup {
+	if r.unavailable {
+		return &robotsGroup{disallowAll: true}
+	}
+
+	merged := &robotsGroup{}
+	for _, name := range []string{userAgent, "*"} {
+		var found bool
+		for _, group := range r.groups {
+			for _, groupUserAgent := range group.userAgents {
+				if groupUserAgent == name {
+					found = true
+					merged.rules = append(merged.rules, group.rules...)
+					if group.crawlDelay > merged.crawlDelay {
+						merged.crawlDelay = group.crawlDelay
+					}
+					break
+				}
+			}
+		}
+		if found {
+			break
+		}
+	}
+
+	return merged
+}
+
+// allows reports whether the group allows path, along with the pattern of the
+// rule which disallows it if not. The longest matching rule wins, and Allow
+// rules win ties.
+func (g *robotsGroup) allows(path string) (string, bool) {
+	if g.disallowAll {
+		return "", false
+	}
+
+	var match *robotsRule
+	for i, rule := range g.rules {
+		if !rule.regexp.MatchString(path) {
+			continue
+		}
+		if match == nil || len(rule.pattern) > len(match.pattern) || (len(rule.pattern) == len(match.pattern) && rule.allow) {
+			match = &g.rules[i]
+		}
+	}
+
+	if match == nil || match.allow {
+		return "", true
+	}
+
+	return match.pattern, false
+}
@@ -0,0 +1,194 @@
+package net_test
+
+import (
+	gocontext "context"
+	"github.com/mdelillo/go-utils/net"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+	assertpkg "github.com/stretchr/testify/assert"
+	requirepkg "github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRobotsPolicy(t *testing.T) {
+	spec.Run(t, "RobotsPolicy", testRobotsPolicy, spec.Report(report.Terminal{}))
+}
+
+func testRobotsPolicy(t *testing.T, context spec.G, it spec.S) {
+	var (
+		server *httptest.Server
+
+		mu                sync.Mutex
+		robotsTxt         string
+		robotsStatusCode  int
+		robotsRequests    int
+		robotsDelay       time.Duration
+		requestedPaths    []string
+		requestTimestamps []time.Time
+
+		assert  = assertpkg.New(t)
+		require = requirepkg.New(t)
+	)
+
+	it.Before(func() {
+		robotsTxt = ""
+		robotsStatusCode = http.StatusOK
+		robotsRequests = 0
+		robotsDelay = 0
+		requestedPaths = nil
+		requestTimestamps = nil
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if r.URL.Path == "/robots.txt" {
+				robotsRequests++
+
+				delay := robotsDelay
+				mu.Unlock()
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+				}
+				mu.Lock()
+
+				w.WriteHeader(robotsStatusCode)
+				_, _ = w.Write([]byte(robotsTxt))
+				return
+			}
+
+			requestedPaths = append(requestedPaths, r.URL.RequestURI())
+			requestTimestamps = append(requestTimestamps, time.Now())
+		}))
+	})
+
+	it.After(func() {
+		server.Close()
+	})
+
+	get := func(browser *net.Browser, path string) error {
+		resp, err := browser.Get(server.URL + path)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	it("rejects requests disallowed for the user agent", func() {
+		robotsTxt = `# Some comment
+User-agent: *
+Disallow: /
+
+User-agent: SomeBot
+User-agent: some-other-bot
+Disallow: /private # some trailing comment
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /search?*q=
+
+User-agent: some-third-bot
+Disallow: /
+`
+		browser := net.NewBrowser(net.WithRobotsPolicy(net.NewRobotsPolicy("somebot")))
+
+		require.NoError(get(browser, "/some-page"))
+		require.NoError(get(browser, "/private/public/some-page"))
+		require.NoError(get(browser, "/some-file.pdf?download=true"))
+		require.NoError(get(browser, "/search?page=1"))
+
+		for _, path := range []string{"/private", "/private/some-page", "/some-dir/some-file.pdf", "/search?page=1&q=some-query"} {
+			err := get(browser, path)
+
+			var disallowedErr *net.RobotsDisallowedError
+			require.ErrorAs(err, &disallowedErr, path)
+			assert.Equal(server.URL+path, disallowedErr.URL)
+			assert.Equal("somebot", disallowedErr.UserAgent)
+		}
+
+		err := get(browser, "/private/some-page")
+		assert.EqualError(err, "robots.txt disallows "+server.URL+"/private/some-page for somebot (Disallow: /private)")
+
+		assert.Equal([]string{"/some-page", "/private/public/some-page", "/some-file.pdf?download=true", "/search?page=1"}, requestedPaths)
+		assert.Equal(1, robotsRequests)
+	})
+
+	it("uses the product token of the User-Agent header and falls back to the * group", func() {
+		robotsTxt = "User-agent: somebot\nDisallow: /some-bot-page\n\nUser-agent: *\nDisallow: /other-page\n"
+
+		browser := net.NewBrowser(
+			net.WithDefaultUserAgent("SomeBot/1.0 (+https://example.com/bot)"),
+			net.WithRobotsPolicy(&net.RobotsPolicy{}),
+		)
+		assert.NoError(get(browser, "/other-page"))
+		assert.Error(get(browser, "/some-bot-page"))
+
+		otherBrowser := net.NewBrowser(net.WithRobotsPolicy(net.NewRobotsPolicy("other-bot")))
+		assert.NoError(get(otherBrowser, "/some-bot-page"))
+		assert.Error(get(otherBrowser, "/other-page"))
+	})
+
+	it("allows everything when there is no robots.txt", func() {
+		robotsStatusCode = http.StatusNotFound
+		robotsTxt = "User-agent: *\nDisallow: /\n"
+		browser := net.NewBrowser(net.WithRobotsPolicy(net.NewRobotsPolicy("somebot")))
+
+		assert.NoError(get(browser, "/some-page"))
+	})
+
+	it("disallows everything when robots.txt is unavailable", func() {
+		robotsStatusCode = http.StatusServiceUnavailable
+		browser := net.NewBrowser(net.WithRobotsPolicy(net.NewRobotsPolicy("somebot")))
+
+		var disallowedErr *net.RobotsDisallowedError
+		assert.ErrorAs(get(browser, "/some-page"), &disallowedErr)
+		assert.Empty(disallowedErr.Rule)
+	})
+
+	it("fetches robots.txt again once it expires", func() {
+		robotsTxt = "User-agent: *\nDisallow: /some-page\n"
+		browser := net.NewBrowser(net.WithRobotsPolicy(&net.RobotsPolicy{UserAgent: "somebot", CacheDuration: 50 * time.Millisecond}))
+		assert.Error(get(browser, "/some-page"))
+
+		mu.Lock()
+		robotsTxt = ""
+		mu.Unlock()
+		assert.Error(get(browser, "/some-page"))
+
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(get(browser, "/some-page"))
+		assert.Equal(2, robotsRequests)
+	})
+
+	it("stops fetching robots.txt when the request is cancelled", func() {
+		robotsDelay = time.Hour
+		browser := net.NewBrowser(net.WithRobotsPolicy(net.NewRobotsPolicy("somebot")))
+
+		ctx, cancel := gocontext.WithCancel(gocontext.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := browser.Get(server.URL+"/some-page", net.WithContext(ctx))
+		assert.ErrorIs(err, gocontext.Canceled)
+		assert.Less(time.Since(start), 5*time.Second)
+		assert.Empty(requestedPaths)
+	})
+
+	it("waits for the Crawl-delay between requests to the host", func() {
+		robotsTxt = "User-agent: somebot\nCrawl-delay: 0.2\nAllow: /\n"
+		browser := net.NewBrowser(
+			net.WithRobotsPolicy(net.NewRobotsPolicy("somebot")),
+			net.WithDefaultRateLimiter(&net.BasicRateLimiter{RequestDelay: 10 * time.Millisecond}),
+		)
+
+		require.NoError(get(browser, "/some-page"))
+		require.NoError(get(browser, "/some-other-page"))
+
+		require.Len(requestTimestamps, 2)
+		assert.GreaterOrEqual(requestTimestamps[1].Sub(requestTimestamps[0]), 200*time.Millisecond)
+	})
+}
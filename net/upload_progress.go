@@ -0,0 +1,78 @@
+package net
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+type UploadProgress struct {
+	// TotalBytes is -1 if the size of the request body is unknown.
+	TotalBytes    int64
+	UploadedBytes int64
+	// Attempt is the number of the attempt to send the request, starting
+	// from 1. Progress starts over from zero when a request is retried.
+	Attempt    int
+	UploadTime time.Duration
+	// Done is set on the last update for an attempt, once the whole body has
+	// been sent.
+	Done bool
+}
+
+type UploadProgressCallback func(UploadProgress)
+
+// WithUploadProgress calls callback as the body of the request is sent.
+func WithUploadProgress(callback UploadProgressCallback) func(_ *http.Request, opts *requestOptions) {
+	return func(_ *http.Request, opts *requestOptions) {
+		opts.onUploadProgress = callback
+	}
+}
+
+// uploadProgressReader reports the bytes read from a request body as they are
+// sent.
+type uploadProgressReader struct {
+	body     io.ReadCloser
+	callback UploadProgressCallback
+	progress UploadProgress
+	start    time.Time
+}
+
+func newUploadProgressReader(req *http.Request, attempt int, callback UploadProgressCallback) *uploadProgressReader {
+	totalBytes := req.ContentLength
+	if totalBytes == 0 {
+		// Requests with a body and no length are sent chunked.
+		totalBytes = -1
+	}
+
+	return &uploadProgressReader{
+		body:     req.Body,
+		callback: callback,
+		progress: UploadProgress{TotalBytes: totalBytes, Attempt: attempt},
+	}
+}
+
+func (r *uploadProgressReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+
+	n, err := r.body.Read(p)
+	if n == 0 && err == nil {
+		return n, err
+	}
+
+	if r.progress.Done {
+		return n, err
+	}
+
+	r.progress.UploadedBytes += int64(n)
+	r.progress.UploadTime = time.Since(r.start)
+	r.progress.Done = err == io.EOF || r.progress.UploadedBytes == r.progress.TotalBytes
+	r.callback(r.progress)
+
+	return n, err
+}
+
+func (r *uploadProgressReader) Close() error {
+	return r.body.Close()
+}